@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// apiListMetadata describes the page of results returned by the contact list endpoint.
+type apiListMetadata struct {
+	Page       int    `json:"page"`
+	Size       int    `json:"size"`
+	Total      int    `json:"total"`
+	TotalPages int    `json:"total_pages"`
+	Query      string `json:"query,omitempty"`
+	Sort       string `json:"sort,omitempty"`
+}
+
+// apiListContacts returns a page of contacts, accepting the same q, page, size and sort query
+// parameters as the HTML contact list.
+func (app *application) apiListContacts(w http.ResponseWriter, r *http.Request) {
+	data, err := app.contactsIndex(r, r.URL.Query())
+	if err != nil {
+		app.serverErrorJSON(w, r, err)
+		return
+	}
+
+	metadata := apiListMetadata{
+		Page:       data.Page,
+		Size:       data.Size,
+		Total:      data.Total,
+		TotalPages: data.TotalPages(),
+		Query:      data.Query,
+		Sort:       data.Sort,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"contacts": data.Contacts, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorJSON(w, r, err)
+	}
+}
+
+// apiGetContact returns a single contact.
+func (app *application) apiGetContact(w http.ResponseWriter, r *http.Request) {
+	contact, ok := app.apiContactFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"contact": contact}, nil)
+	if err != nil {
+		app.serverErrorJSON(w, r, err)
+	}
+}
+
+// apiCreateContact creates a contact from a JSON body and responds with 201 Created and its location.
+func (app *application) apiCreateContact(w http.ResponseWriter, r *http.Request) {
+	form := models.ContactForm{}
+
+	if err := app.readJSON(w, r, &form); err != nil {
+		app.errorJSON(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	validateContactForm(&form, app.bookContacts(r), 0)
+
+	if !form.Valid() {
+		app.failedValidationJSON(w, r, apiValidationErrors(form.Errors))
+		return
+	}
+
+	contact := models.Contact{
+		First: form.First,
+		Last:  form.Last,
+		Phone: form.Phone,
+		Email: form.Email,
+	}
+
+	err := app.bookContacts(r).Insert(&contact)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			app.failedValidationJSON(w, r, map[string]string{"email": "Email is already in use."})
+		} else {
+			app.serverErrorJSON(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/contacts/%d", contact.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"contact": contact}, headers)
+	if err != nil {
+		app.serverErrorJSON(w, r, err)
+	}
+}
+
+// apiUpdateContact replaces the fields of an existing contact from a JSON body.
+func (app *application) apiUpdateContact(w http.ResponseWriter, r *http.Request) {
+	contact, ok := app.apiContactFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	form := models.ContactForm{ID: contact.ID}
+
+	if err := app.readJSON(w, r, &form); err != nil {
+		app.errorJSON(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	validateContactForm(&form, app.bookContacts(r), contact.ID)
+
+	if !form.Valid() {
+		app.failedValidationJSON(w, r, apiValidationErrors(form.Errors))
+		return
+	}
+
+	contact.First = form.First
+	contact.Last = form.Last
+	contact.Phone = form.Phone
+	contact.Email = form.Email
+
+	err := app.bookContacts(r).Update(contact)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateEmail):
+			app.failedValidationJSON(w, r, map[string]string{"email": "Email is already in use."})
+		case errors.Is(err, models.ErrNoRecord):
+			app.errorJSON(w, r, http.StatusNotFound, "the requested contact could not be found")
+		default:
+			app.serverErrorJSON(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"contact": contact}, nil)
+	if err != nil {
+		app.serverErrorJSON(w, r, err)
+	}
+}
+
+// apiDeleteContact moves a contact to the trash and responds with 204 No Content.
+func (app *application) apiDeleteContact(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.errorJSON(w, r, http.StatusNotFound, "the requested contact could not be found")
+		return
+	}
+
+	err = app.bookContacts(r).Delete(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorJSON(w, r, http.StatusNotFound, "the requested contact could not be found")
+		} else {
+			app.serverErrorJSON(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiContactFromPath loads the contact named by the {id} path value. If it cannot be loaded an
+// error response has already been written and ok is false.
+func (app *application) apiContactFromPath(w http.ResponseWriter, r *http.Request) (*models.Contact, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.errorJSON(w, r, http.StatusNotFound, "the requested contact could not be found")
+		return nil, false
+	}
+
+	contact, err := app.bookContacts(r).Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorJSON(w, r, http.StatusNotFound, "the requested contact could not be found")
+		} else {
+			app.serverErrorJSON(w, r, err)
+		}
+		return nil, false
+	}
+
+	return contact, true
+}
+
+// apiValidationErrors converts validator errors, keyed by ContactForm field name, to the JSON field
+// names clients send and receive.
+func apiValidationErrors(errs map[string]string) map[string]string {
+	converted := make(map[string]string, len(errs))
+	for field, message := range errs {
+		converted[strings.ToLower(field)] = message
+	}
+	return converted
+}
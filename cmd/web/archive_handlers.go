@@ -0,0 +1,140 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"github.com/code-chimp/htmx-go-example/internal/services"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+)
+
+// archiveFiles lists the files in a contact archive, each holding every contact of the book.
+var archiveFiles = []struct {
+	name  string
+	write func(io.Writer, []*models.Contact, func(int) error) error
+}{
+	{"contacts.json", streamContactsJSON},
+	{"contacts.csv", streamContactsCSV},
+	{"contacts.vcf", streamContactsVCard},
+}
+
+// archiveKey identifies the archive of the current address book that belongs to the requester, so
+// that people sharing a book do not reset each other's downloads.
+func archiveKey(r *http.Request) string {
+	owner := "anonymous"
+	if token := contextGetToken(r); token != nil {
+		owner = fmt.Sprintf("token %d", token.ID)
+	} else if user := contextGetUser(r); user != nil {
+		owner = fmt.Sprintf("user %d", user.ID)
+	}
+
+	return fmt.Sprintf("book %d, %s", contextGetBook(r).ID, owner)
+}
+
+// getArchive renders the archive status fragment, which keeps polling itself while the archive is
+// being built.
+func (app *application) getArchive(w http.ResponseWriter, r *http.Request) {
+	app.renderArchive(w, r, app.archives.Status(archiveKey(r)))
+}
+
+// postArchive starts building an archive of the current address book in the background and
+// renders its status.
+func (app *application) postArchive(w http.ResponseWriter, r *http.Request) {
+	store := app.contacts.InBook(contextGetBook(r).ID)
+
+	archive, err := app.archives.Start(archiveKey(r), archiveBuilder(store))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.renderArchive(w, r, archive)
+}
+
+// deleteArchive cancels or discards the requester's archive and renders the start button again.
+func (app *application) deleteArchive(w http.ResponseWriter, r *http.Request) {
+	key := archiveKey(r)
+	app.archives.Reset(key)
+
+	app.renderArchive(w, r, app.archives.Status(key))
+}
+
+// getArchiveFile downloads the requester's finished archive.
+func (app *application) getArchiveFile(w http.ResponseWriter, r *http.Request) {
+	archive := app.archives.Status(archiveKey(r))
+	if archive.Status != models.ArchiveComplete {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(archive.Path)
+	if err != nil {
+		// the archive was cleared while the link was on screen
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	filename := fmt.Sprintf("contacts-%s.zip", archive.StartedAt.Format("2006-01-02"))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Cache-Control", "no-store")
+
+	http.ServeContent(w, r, filename, archive.FinishedAt, f)
+}
+
+// renderArchive writes the archive status fragment.
+func (app *application) renderArchive(w http.ResponseWriter, r *http.Request, archive models.Archive) {
+	app.renderPartial(w, r, http.StatusOK, "contacts.index.go.tmpl", "archive-ui", archive)
+}
+
+// archiveBuilder returns a job that writes every contact in the store to a zip file holding the
+// archiveFiles. Progress counts each contact once per file.
+func archiveBuilder(store services.ContactStore) services.ArchiveBuilder {
+	return func(ctx context.Context, w io.Writer, progress func(done, total int)) error {
+		contacts, err := store.GetAll()
+		if err != nil {
+			return err
+		}
+
+		total := len(contacts) * len(archiveFiles)
+		progress(0, total)
+
+		zw := zip.NewWriter(w)
+		modified := time.Now()
+
+		for i, file := range archiveFiles {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			fw, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: modified})
+			if err != nil {
+				return err
+			}
+
+			done := i * len(contacts)
+			err = file.write(fw, contacts, func(written int) error {
+				progress(done+written, total)
+				return ctx.Err()
+			})
+			if err != nil {
+				return err
+			}
+
+			progress(done+len(contacts), total)
+		}
+
+		return zw.Close()
+	}
+}
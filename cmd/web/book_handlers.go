@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"github.com/code-chimp/htmx-go-example/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// currentBookIDKey is the session key holding the ID of the address book chosen in the header.
+const currentBookIDKey = "currentBookID"
+
+// getBooks displays the address books the user can access, with forms to create and share books.
+func (app *application) getBooks(w http.ResponseWriter, r *http.Request) {
+	data := models.AddressBooksVM{
+		Books: app.books.ForUser(contextGetUser(r).ID),
+	}
+
+	app.render(w, r, http.StatusOK, "books.index.go.tmpl", data)
+}
+
+// postNewBook creates an address book owned by the current user and switches to it.
+func (app *application) postNewBook(w http.ResponseWriter, r *http.Request) {
+	user := contextGetUser(r)
+	form := models.AddressBookForm{}
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Name = strings.TrimSpace(form.Name)
+
+	form.CheckField(validator.NotBlank(form.Name), "Name", "Name is required.")
+	form.CheckField(validator.MaxChars(form.Name, 100), "Name", "Name cannot be longer than 100 characters.")
+
+	if !form.Valid() {
+		data := models.AddressBooksVM{
+			Books: app.books.ForUser(user.ID),
+			Form:  form,
+		}
+		app.render(w, r, http.StatusUnprocessableEntity, "books.index.go.tmpl", data)
+		return
+	}
+
+	book, err := app.books.Create(form.Name, user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), currentBookIDKey, book.ID)
+	app.sessionManager.Put(r.Context(), flashKey, fmt.Sprintf("Address book %q created.", book.Name))
+
+	http.Redirect(w, r, "/contacts", http.StatusSeeOther)
+}
+
+// postBookMember shares one of the current user's address books with another registered user.
+func (app *application) postBookMember(w http.ResponseWriter, r *http.Request) {
+	user := contextGetUser(r)
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	book, err := app.books.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// only the owner decides who else sees a book; the shared book has no owner
+	if book.OwnerID != user.ID {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	form := models.AddressBookMemberForm{}
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Email = strings.TrimSpace(form.Email)
+
+	form.CheckField(validator.NotBlank(form.Email), "Email", "Email is required.")
+
+	var member *models.User
+	if form.Valid() {
+		member, err = app.users.GetByEmail(form.Email)
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.serverError(w, r, err)
+				return
+			}
+			form.AddError("Email", "No user is registered with that email address.")
+		}
+	}
+
+	if !form.Valid() {
+		data := models.AddressBooksVM{
+			Books:        app.books.ForUser(user.ID),
+			MemberForm:   form,
+			MemberBookID: book.ID,
+		}
+		app.render(w, r, http.StatusUnprocessableEntity, "books.index.go.tmpl", data)
+		return
+	}
+
+	err = app.books.AddMember(book.ID, member.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), flashKey, fmt.Sprintf("%s can now use %q.", member.Name, book.Name))
+
+	http.Redirect(w, r, "/books", http.StatusSeeOther)
+}
+
+// postSwitchBook makes another address book the current one and shows its contacts.
+func (app *application) postSwitchBook(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PostForm.Get("book"))
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	book, err := app.books.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if !book.CanAccess(contextGetUser(r).ID) {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), currentBookIDKey, book.ID)
+
+	// the page on screen may show a contact from the previous book, so always go back to the list
+	if isHTMXRequest(r) {
+		w.Header().Set("HX-Redirect", "/contacts")
+		return
+	}
+
+	http.Redirect(w, r, "/contacts", http.StatusSeeOther)
+}
@@ -0,0 +1,809 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"github.com/code-chimp/htmx-go-example/internal/services"
+	"github.com/code-chimp/htmx-go-example/internal/vcard"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// XML namespaces of WebDAV (RFC 4918), CardDAV (RFC 6352) and the calendarserver.org extensions
+// that many clients still look for.
+const (
+	davNS            = "DAV:"
+	cardDAVNS        = "urn:ietf:params:xml:ns:carddav"
+	calendarServerNS = "http://calendarserver.org/ns/"
+)
+
+const (
+	// davPrincipalPath is the principal that stands for whoever signed in.
+	davPrincipalPath = "/carddav/principal/"
+	// davHomePath is the collection holding an address book collection per accessible book.
+	davHomePath = "/carddav/books/"
+	// maxDAVBytes limits the size of request bodies, both vCards and XML.
+	maxDAVBytes = 1 << 20
+	// davSyncTokenPrefix starts every sync token; the rest is the ID of an audit log entry.
+	davSyncTokenPrefix = "urn:x-contacts:sync:"
+)
+
+// Names of the properties and preconditions the endpoint knows about.
+var (
+	davResourceType            = xml.Name{Space: davNS, Local: "resourcetype"}
+	davDisplayName             = xml.Name{Space: davNS, Local: "displayname"}
+	davGetETag                 = xml.Name{Space: davNS, Local: "getetag"}
+	davGetContentType          = xml.Name{Space: davNS, Local: "getcontenttype"}
+	davCurrentUserPrincipal    = xml.Name{Space: davNS, Local: "current-user-principal"}
+	davCurrentUserPrivilegeSet = xml.Name{Space: davNS, Local: "current-user-privilege-set"}
+	davPrincipalURL            = xml.Name{Space: davNS, Local: "principal-URL"}
+	davSupportedReportSet      = xml.Name{Space: davNS, Local: "supported-report-set"}
+	davSyncToken               = xml.Name{Space: davNS, Local: "sync-token"}
+	davSupportedReport         = xml.Name{Space: davNS, Local: "supported-report"}
+	davValidSyncToken          = xml.Name{Space: davNS, Local: "valid-sync-token"}
+	davMatchesWithinLimits     = xml.Name{Space: davNS, Local: "number-of-matches-within-limits"}
+	cardAddressbookHomeSet     = xml.Name{Space: cardDAVNS, Local: "addressbook-home-set"}
+	cardSupportedAddressData   = xml.Name{Space: cardDAVNS, Local: "supported-address-data"}
+	cardMaxResourceSize        = xml.Name{Space: cardDAVNS, Local: "max-resource-size"}
+	cardAddressData            = xml.Name{Space: cardDAVNS, Local: "address-data"}
+	cardValidAddressData       = xml.Name{Space: cardDAVNS, Local: "valid-address-data"}
+	cardSupportedFilter        = xml.Name{Space: cardDAVNS, Local: "supported-filter"}
+	cardSupportedCollation     = xml.Name{Space: cardDAVNS, Local: "supported-collation"}
+	csGetCTag                  = xml.Name{Space: calendarServerNS, Local: "getctag"}
+)
+
+// Errors returned when an addressbook-query uses a filter the endpoint cannot evaluate.
+var (
+	errDAVFilter    = errors.New("carddav: unsupported filter")
+	errDAVCollation = errors.New("carddav: unsupported collation")
+)
+
+// davLegacyNameRX matches the resource names of contacts that were not created over CardDAV. New
+// resources may not take such a name.
+var davLegacyNameRX = regexp.MustCompile(`^contact-[0-9]+$`)
+
+// davPropRequest lists the properties asked for in a <prop> element, along with the vCard version
+// requested by <C:address-data version="..."/>.
+type davPropRequest struct {
+	Names   []xml.Name
+	Version string
+}
+
+// UnmarshalXML records the name of every child element, whatever its namespace.
+func (p *davPropRequest) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			p.Names = append(p.Names, t.Name)
+			if t.Name == cardAddressData {
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "version" {
+						p.Version = attr.Value
+					}
+				}
+			}
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// addressData returns the vCard version to include as address-data, or "" if it was not asked for.
+// A nil request, as for allprop, never includes it.
+func (p *davPropRequest) addressData() string {
+	if p == nil || !slices.Contains(p.Names, cardAddressData) {
+		return ""
+	}
+
+	if p.Version == vcard.Version4 {
+		return vcard.Version4
+	}
+	return vcard.Version3
+}
+
+// davPropfind is the body of a PROPFIND request. An empty body means allprop.
+type davPropfind struct {
+	XMLName  xml.Name        `xml:"DAV: propfind"`
+	AllProp  *struct{}       `xml:"DAV: allprop"`
+	PropName *struct{}       `xml:"DAV: propname"`
+	Prop     *davPropRequest `xml:"DAV: prop"`
+}
+
+// davLimit caps the number of responses to a REPORT.
+type davLimit struct {
+	NResults int `xml:"nresults"`
+}
+
+// davAddressbookQuery is the body of an addressbook-query REPORT.
+type davAddressbookQuery struct {
+	XMLName xml.Name        `xml:"urn:ietf:params:xml:ns:carddav addressbook-query"`
+	Prop    *davPropRequest `xml:"DAV: prop"`
+	Filter  davFilter       `xml:"urn:ietf:params:xml:ns:carddav filter"`
+	Limit   *davLimit       `xml:"urn:ietf:params:xml:ns:carddav limit"`
+}
+
+// davMultiget is the body of an addressbook-multiget REPORT.
+type davMultiget struct {
+	XMLName xml.Name        `xml:"urn:ietf:params:xml:ns:carddav addressbook-multiget"`
+	Prop    *davPropRequest `xml:"DAV: prop"`
+	Hrefs   []string        `xml:"DAV: href"`
+}
+
+// davSyncCollection is the body of a sync-collection REPORT (RFC 6578).
+type davSyncCollection struct {
+	XMLName   xml.Name        `xml:"DAV: sync-collection"`
+	SyncToken string          `xml:"DAV: sync-token"`
+	Prop      *davPropRequest `xml:"DAV: prop"`
+	Limit     *davLimit       `xml:"DAV: limit"`
+}
+
+// davFilter is the filter of an addressbook-query. It matches contacts that pass any, or with
+// test="allof" every, prop-filter. An empty filter matches every contact.
+type davFilter struct {
+	Test  string          `xml:"test,attr"`
+	Props []davPropFilter `xml:"urn:ietf:params:xml:ns:carddav prop-filter"`
+}
+
+// davPropFilter tests one vCard property, either for being absent or against text-matches.
+type davPropFilter struct {
+	Name         string         `xml:"name,attr"`
+	Test         string         `xml:"test,attr"`
+	IsNotDefined *struct{}      `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatches  []davTextMatch `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+	ParamFilters []struct{}     `xml:"urn:ietf:params:xml:ns:carddav param-filter"`
+}
+
+// davTextMatch compares the values of a vCard property with a string.
+type davTextMatch struct {
+	Collation string `xml:"collation,attr"`
+	MatchType string `xml:"match-type,attr"`
+	Negate    string `xml:"negate-condition,attr"`
+	Text      string `xml:",chardata"`
+}
+
+// check returns errDAVFilter or errDAVCollation if the filter uses something matches cannot
+// evaluate. Parameters are not stored, so param-filters are not supported.
+func (f davFilter) check() error {
+	for _, pf := range f.Props {
+		if len(pf.ParamFilters) > 0 {
+			return errDAVFilter
+		}
+
+		for _, tm := range pf.TextMatches {
+			switch tm.Collation {
+			case "", "i;unicode-casemap", "i;ascii-casemap", "i;octet":
+			default:
+				return errDAVCollation
+			}
+
+			switch tm.MatchType {
+			case "", "contains", "equals", "starts-with", "ends-with":
+			default:
+				return errDAVFilter
+			}
+		}
+	}
+
+	return nil
+}
+
+// matches reports whether the contact passes the filter.
+func (f davFilter) matches(c *models.Contact) bool {
+	if len(f.Props) == 0 {
+		return true
+	}
+
+	return davTest(f.Test, f.Props, func(pf davPropFilter) bool { return pf.matches(c) })
+}
+
+// matches reports whether the contact passes the prop-filter. A property the contact does not
+// have never passes its text-matches, negated or not.
+func (pf davPropFilter) matches(c *models.Contact) bool {
+	values := davPropertyValues(c, pf.Name)
+
+	switch {
+	case pf.IsNotDefined != nil:
+		return len(values) == 0
+	case len(values) == 0:
+		return false
+	case len(pf.TextMatches) == 0:
+		return true
+	}
+
+	return davTest(pf.Test, pf.TextMatches, func(tm davTextMatch) bool { return tm.matches(values) })
+}
+
+// matches reports whether any of the values matches the text, or none does when negated.
+func (tm davTextMatch) matches(values []string) bool {
+	fold := strings.ToLower
+	switch tm.Collation {
+	case "i;octet":
+		fold = func(s string) string { return s }
+	case "i;ascii-casemap":
+		fold = func(s string) string {
+			return strings.Map(func(r rune) rune {
+				if 'A' <= r && r <= 'Z' {
+					return r + 'a' - 'A'
+				}
+				return r
+			}, s)
+		}
+	}
+
+	text := fold(tm.Text)
+	matched := slices.ContainsFunc(values, func(value string) bool {
+		value = fold(value)
+
+		switch tm.MatchType {
+		case "equals":
+			return value == text
+		case "starts-with":
+			return strings.HasPrefix(value, text)
+		case "ends-with":
+			return strings.HasSuffix(value, text)
+		default:
+			return strings.Contains(value, text)
+		}
+	})
+
+	if tm.Negate == "yes" {
+		return !matched
+	}
+	return matched
+}
+
+// davTest combines the results of match over items: all of them must match if test is "allof",
+// otherwise any of them.
+func davTest[T any](test string, items []T, match func(T) bool) bool {
+	if test == "allof" {
+		return !slices.ContainsFunc(items, func(item T) bool { return !match(item) })
+	}
+	return slices.ContainsFunc(items, match)
+}
+
+// davPropertyValues returns the values the contact's vCard has for a property, as written by
+// davCard.
+func davPropertyValues(c *models.Contact, name string) []string {
+	var value string
+
+	switch strings.ToUpper(name) {
+	case "UID":
+		value = davResourceName(c)
+	case "FN":
+		value = strings.TrimSpace(c.First + " " + c.Last)
+	case "N":
+		value = c.Last + ";" + c.First + ";;;"
+	case "TEL":
+		value = c.Phone
+	case "EMAIL":
+		value = c.Email
+	}
+
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// davProperty is a property in a multistatus response. Text is escaped, while Inner holds nested
+// elements and is written as is.
+type davProperty struct {
+	XMLName xml.Name
+	Text    string `xml:",chardata"`
+	Inner   string `xml:",innerxml"`
+}
+
+// davMultistatus is the body of a 207 Multi-Status response.
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"DAV: multistatus"`
+	Responses []davResponse `xml:"response"`
+	SyncToken string        `xml:"sync-token,omitempty"`
+}
+
+// davResponse describes one resource in a multistatus response, either with its properties or,
+// for resources that are gone, with just a status.
+type davResponse struct {
+	Href      string        `xml:"href"`
+	Status    string        `xml:"status,omitempty"`
+	Propstats []davPropstat `xml:"propstat"`
+}
+
+// davPropstat groups properties that share a status.
+type davPropstat struct {
+	Prop   davProp `xml:"prop"`
+	Status string  `xml:"status"`
+}
+
+// davProp holds the properties of a propstat, each named by its own XMLName.
+type davProp struct {
+	Properties []davProperty
+}
+
+// davResource is a resource along with every property it has.
+type davResource struct {
+	href  string
+	props []davProperty
+}
+
+// response answers a request for the resource's properties: every property when req is nil, as
+// for allprop, only their names for propname, and otherwise the ones asked for, with those the
+// resource lacks listed as not found.
+func (res davResource) response(req *davPropRequest, propName bool) davResponse {
+	var found, missing []davProperty
+
+	switch {
+	case propName:
+		for _, p := range res.props {
+			found = append(found, davProperty{XMLName: p.XMLName})
+		}
+	case req == nil:
+		found = res.props
+	default:
+		for _, name := range req.Names {
+			i := slices.IndexFunc(res.props, func(p davProperty) bool { return p.XMLName == name })
+			if i >= 0 {
+				found = append(found, res.props[i])
+			} else {
+				missing = append(missing, davProperty{XMLName: name})
+			}
+		}
+	}
+
+	resp := davResponse{Href: res.href}
+	if len(found) > 0 || len(missing) == 0 {
+		resp.Propstats = append(resp.Propstats, davPropstat{Prop: davProp{found}, Status: davStatus(http.StatusOK)})
+	}
+	if len(missing) > 0 {
+		resp.Propstats = append(resp.Propstats, davPropstat{Prop: davProp{missing}, Status: davStatus(http.StatusNotFound)})
+	}
+
+	return resp
+}
+
+// davText returns a property holding text.
+func davText(name xml.Name, text string) davProperty {
+	return davProperty{XMLName: name, Text: text}
+}
+
+// davInner returns a property holding the given XML elements.
+func davInner(name xml.Name, inner string) davProperty {
+	return davProperty{XMLName: name, Inner: inner}
+}
+
+// davHref returns an href element pointing at path.
+func davHref(path string) string {
+	var b strings.Builder
+	b.WriteString("<href>")
+	xml.EscapeText(&b, []byte(path))
+	b.WriteString("</href>")
+	return b.String()
+}
+
+// davStatus returns the status line used in multistatus responses.
+func davStatus(code int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, http.StatusText(code))
+}
+
+// davBookPath returns the path of an address book's collection.
+func davBookPath(bookID int) string {
+	return fmt.Sprintf("%s%d/", davHomePath, bookID)
+}
+
+// davContactPath returns the path of a contact's vCard resource.
+func davContactPath(bookID int, name string) string {
+	return davBookPath(bookID) + url.PathEscape(name) + ".vcf"
+}
+
+// davResourceName returns the name of a contact's resource, without the .vcf extension: the UID it
+// was created with over CardDAV, or contact-<id> for contacts created anywhere else.
+func davResourceName(c *models.Contact) string {
+	if c.UID != "" {
+		return c.UID
+	}
+	return "contact-" + strconv.Itoa(c.ID)
+}
+
+// davNameFromFile returns the resource name for the last segment of a resource's path, or false
+// if it is not a usable name. Resources are always vCard files.
+func davNameFromFile(file string) (string, bool) {
+	name, ok := strings.CutSuffix(file, ".vcf")
+	if !ok || name == "" || len(name) > 255 || !utf8.ValidString(name) ||
+		strings.ContainsFunc(name, func(r rune) bool { return r == '/' || unicode.IsControl(r) }) {
+		return "", false
+	}
+
+	return name, true
+}
+
+// davNameFromHref returns the resource name for an href in the given book's collection, which
+// may be a path or a full URL, or false if it points anywhere else.
+func davNameFromHref(bookID int, href string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", false
+	}
+
+	file, ok := strings.CutPrefix(u.Path, davBookPath(bookID))
+	if !ok {
+		return "", false
+	}
+
+	return davNameFromFile(file)
+}
+
+// findDAVContact returns the contact whose resource has the given name, or models.ErrNoRecord.
+func findDAVContact(store services.ContactStore, name string) (*models.Contact, error) {
+	if davLegacyNameRX.MatchString(name) {
+		id, err := strconv.Atoi(strings.TrimPrefix(name, "contact-"))
+		if err != nil {
+			return nil, models.ErrNoRecord
+		}
+
+		contact, err := store.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if contact.UID != "" {
+			return nil, models.ErrNoRecord
+		}
+		return contact, nil
+	}
+
+	return store.GetByUID(name)
+}
+
+// davCard encodes the contact as a vCard of the given version, using the resource name as UID so
+// that clients can match cards to resources.
+func davCard(c *models.Contact, version string) ([]byte, error) {
+	card := *c
+	card.UID = davResourceName(c)
+
+	var buf bytes.Buffer
+	if err := vcard.WriteVersion(&buf, &card, version); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// davETag returns the entity tag of a contact's resource. It changes whenever a field written to
+// the vCard does.
+func davETag(c *models.Contact) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{davResourceName(c), c.First, c.Last, c.Phone, c.Email}, "\x00")))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// davETagMatches reports whether an If-Match or If-None-Match header lists etag or is "*".
+// Weak tags are compared as if they were strong.
+func davETagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// davPreconditionsHold checks the If-Match and If-None-Match headers of a write against the
+// resource's current contact, which is nil if the resource does not exist.
+func davPreconditionsHold(r *http.Request, contact *models.Contact) bool {
+	etag := ""
+	if contact != nil {
+		etag = davETag(contact)
+	}
+
+	if h := r.Header.Get("If-Match"); h != "" && (contact == nil || !davETagMatches(h, etag)) {
+		return false
+	}
+	if h := r.Header.Get("If-None-Match"); h != "" && contact != nil && davETagMatches(h, etag) {
+		return false
+	}
+
+	return true
+}
+
+// davAcceptVersion returns the vCard version asked for by an Accept header. RFC 6352 makes 3.0 the
+// default.
+func davAcceptVersion(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err == nil && mediaType == "text/vcard" && params["version"] == vcard.Version4 {
+			return vcard.Version4
+		}
+	}
+	return vcard.Version3
+}
+
+// davDepth reads the Depth header of a PROPFIND request. The endpoint is never more than one level
+// deep below a collection, so infinity, the default, is the same as 1.
+func davDepth(r *http.Request) (int, bool) {
+	switch r.Header.Get("Depth") {
+	case "0":
+		return 0, true
+	case "1", "infinity", "":
+		return 1, true
+	default:
+		return 0, false
+	}
+}
+
+// davSyncTokenFor returns the sync token for the state of the audit log after the entry with the
+// given ID.
+func davSyncTokenFor(id int) string {
+	return davSyncTokenPrefix + strconv.Itoa(id)
+}
+
+// parseDAVSyncToken returns the audit log entry ID in a sync token, or false if the token was not
+// issued by davSyncTokenFor.
+func parseDAVSyncToken(token string) (int, bool) {
+	digits, ok := strings.CutPrefix(strings.TrimSpace(token), davSyncTokenPrefix)
+	if !ok {
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(digits)
+	if err != nil || id < 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// readDAVBody reads the XML body of a PROPFIND or REPORT request, up to maxDAVBytes. A body of
+// only white space is returned as nil.
+func readDAVBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDAVBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+	return body, nil
+}
+
+// writeMultistatus sends a 207 Multi-Status response.
+func (app *application) writeMultistatus(w http.ResponseWriter, r *http.Request, ms *davMultistatus) {
+	out, err := xml.Marshal(ms)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	w.Write(out)
+}
+
+// davError sends an error response whose body names the precondition that failed, such as
+// CARDDAV:valid-address-data, so that clients can tell why a request was refused.
+func (app *application) davError(w http.ResponseWriter, status int, condition xml.Name) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `%s<error xmlns="DAV:"><%s xmlns="%s"/></error>`, xml.Header, condition.Local, condition.Space)
+}
+
+// davUnauthorized asks the client to sign in with HTTP Basic authentication.
+func (app *application) davUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="Contacts", charset="UTF-8"`)
+	app.clientError(w, http.StatusUnauthorized)
+}
+
+// davCan reports whether the requester, signed in or using an API token, has the permission in
+// the book.
+func davCan(r *http.Request, book *models.AddressBook, p models.Permission) bool {
+	if token := contextGetToken(r); token != nil {
+		return token.Can(p)
+	}
+	if user := contextGetUser(r); user != nil {
+		return user.CanIn(book, p)
+	}
+	return false
+}
+
+// davPrivileges returns the current-user-privilege-set of the requester on an address book, so
+// that clients can tell which books are read-only.
+func davPrivileges(r *http.Request, book *models.AddressBook) string {
+	privileges := []string{"read"}
+	if davCan(r, book, models.PermissionEditContacts) {
+		privileges = append(privileges, "write-content", "bind")
+	}
+	if davCan(r, book, models.PermissionDeleteContacts) {
+		privileges = append(privileges, "unbind")
+	}
+	if len(privileges) == 4 {
+		privileges = append(privileges, "write")
+	}
+
+	var b strings.Builder
+	for _, p := range privileges {
+		fmt.Fprintf(&b, "<privilege><%s/></privilege>", p)
+	}
+	return b.String()
+}
+
+// davBookResource describes an address book collection. The sync token, also served as the
+// getctag clients use to skip unchanged books, comes from the audit log.
+func (app *application) davBookResource(r *http.Request, book *models.AddressBook) davResource {
+	_, newest := app.audit.Changes(book.ID, math.MaxInt)
+	token := davSyncTokenFor(newest)
+
+	return davResource{href: davBookPath(book.ID), props: []davProperty{
+		davInner(davResourceType, `<collection/><addressbook xmlns="`+cardDAVNS+`"/>`),
+		davText(davDisplayName, book.Name),
+		davInner(davCurrentUserPrincipal, davHref(davPrincipalPath)),
+		davInner(davCurrentUserPrivilegeSet, davPrivileges(r, book)),
+		davInner(davSupportedReportSet,
+			`<supported-report><report><addressbook-query xmlns="`+cardDAVNS+`"/></report></supported-report>`+
+				`<supported-report><report><addressbook-multiget xmlns="`+cardDAVNS+`"/></report></supported-report>`+
+				`<supported-report><report><sync-collection/></report></supported-report>`),
+		davText(davSyncToken, token),
+		davText(csGetCTag, token),
+		davInner(cardSupportedAddressData,
+			`<address-data-type content-type="text/vcard" version="3.0"/>`+
+				`<address-data-type content-type="text/vcard" version="4.0"/>`),
+		davText(cardMaxResourceSize, strconv.Itoa(maxDAVBytes)),
+	}}
+}
+
+// davContactResource describes a contact's vCard resource. The card itself is included as
+// address-data in the given vCard version, unless version is "".
+func davContactResource(bookID int, c *models.Contact, version string) (davResource, error) {
+	res := davResource{href: davContactPath(bookID, davResourceName(c)), props: []davProperty{
+		davInner(davResourceType, ""),
+		davText(davGetETag, davETag(c)),
+		davText(davGetContentType, vcard.ContentType),
+	}}
+
+	if version != "" {
+		card, err := davCard(c, version)
+		if err != nil {
+			return davResource{}, err
+		}
+		res.props = append(res.props, davText(cardAddressData, string(card)))
+	}
+
+	return res, nil
+}
+
+// davCredentialTTL is how long a successful CardDAV sign in is remembered. Clients send the
+// password with every request and a sync can take hundreds of them, so checking it against the
+// bcrypt hash each time would make syncing slow and keep the CPU busy.
+const davCredentialTTL = 5 * time.Minute
+
+// davCredential is a remembered CardDAV sign in.
+type davCredential struct {
+	userID         int
+	hashedPassword []byte
+	expires        time.Time
+}
+
+// davCredentialCache remembers the Basic authentication credentials of recent CardDAV sign ins.
+// Credentials are keyed by an HMAC with a key that only lives in memory, so the passwords
+// themselves are never kept. It is safe for concurrent use.
+type davCredentialCache struct {
+	secret []byte
+
+	mu      sync.Mutex
+	entries map[string]davCredential
+}
+
+// newDAVCredentialCache creates an empty cache with a new random key.
+func newDAVCredentialCache() (*davCredentialCache, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	return &davCredentialCache{secret: secret, entries: make(map[string]davCredential)}, nil
+}
+
+// key returns the cache key for the credentials.
+func (c *davCredentialCache) key(email, password string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(email))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return string(mac.Sum(nil))
+}
+
+// get returns the sign in remembered under key, if it has not expired.
+func (c *davCredentialCache) get(key string) (davCredential, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cred, ok := c.entries[key]
+	if ok && time.Now().After(cred.expires) {
+		delete(c.entries, key)
+		return davCredential{}, false
+	}
+
+	return cred, ok
+}
+
+// put remembers that key signs in the user, and forgets every sign in that has expired.
+func (c *davCredentialCache) put(key string, user *models.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, cred := range c.entries {
+		if now.After(cred.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = davCredential{
+		userID:         user.ID,
+		hashedPassword: user.HashedPassword,
+		expires:        now.Add(davCredentialTTL),
+	}
+}
+
+// forget removes the sign in remembered under key.
+func (c *davCredentialCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+// davUser returns the user that the Basic authentication credentials belong to, or
+// models.ErrInvalidCredentials. A remembered sign in is only used while the user still exists with
+// the same password hash; otherwise the password is checked again.
+func (app *application) davUser(email, password string) (*models.User, error) {
+	key := app.davCredentials.key(email, password)
+
+	if cred, ok := app.davCredentials.get(key); ok {
+		user, err := app.users.Get(cred.userID)
+		if err == nil && bytes.Equal(user.HashedPassword, cred.hashedPassword) {
+			return user, nil
+		}
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			return nil, err
+		}
+
+		app.davCredentials.forget(key)
+	}
+
+	id, err := app.users.Authenticate(email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	app.davCredentials.put(key, user)
+
+	return user, nil
+}
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"github.com/code-chimp/htmx-go-example/internal/vcard"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// davOptions advertises CardDAV support to clients probing the endpoint.
+func (app *application) davOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("DAV", "1, 3, addressbook")
+	w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+	w.WriteHeader(http.StatusOK)
+}
+
+// propfindDAVRoot describes the root of the endpoint, which clients reach from /.well-known/carddav
+// to find the current user's principal.
+func (app *application) propfindDAVRoot(w http.ResponseWriter, r *http.Request) {
+	app.propfindDAV(w, r, func(depth int) ([]davResource, error) {
+		return []davResource{{href: "/carddav/", props: []davProperty{
+			davInner(davResourceType, "<collection/>"),
+			davInner(davCurrentUserPrincipal, davHref(davPrincipalPath)),
+		}}}, nil
+	})
+}
+
+// propfindDAVPrincipal describes the principal of whoever signed in, which points clients at the
+// collection of their address books.
+func (app *application) propfindDAVPrincipal(w http.ResponseWriter, r *http.Request) {
+	name := ""
+	if token := contextGetToken(r); token != nil {
+		name = token.Name
+	} else if user := contextGetUser(r); user != nil {
+		name = user.Name
+	}
+
+	app.propfindDAV(w, r, func(depth int) ([]davResource, error) {
+		return []davResource{{href: davPrincipalPath, props: []davProperty{
+			davInner(davResourceType, "<collection/><principal/>"),
+			davText(davDisplayName, name),
+			davInner(davPrincipalURL, davHref(davPrincipalPath)),
+			davInner(davCurrentUserPrincipal, davHref(davPrincipalPath)),
+			davInner(cardAddressbookHomeSet, davHref(davHomePath)),
+		}}}, nil
+	})
+}
+
+// propfindDAVHome describes the collection of address books and, unless Depth is 0, every book
+// the requester may access. An API token only ever sees its own book.
+func (app *application) propfindDAVHome(w http.ResponseWriter, r *http.Request) {
+	app.propfindDAV(w, r, func(depth int) ([]davResource, error) {
+		resources := []davResource{{href: davHomePath, props: []davProperty{
+			davInner(davResourceType, "<collection/>"),
+			davInner(davCurrentUserPrincipal, davHref(davPrincipalPath)),
+		}}}
+
+		if depth == 0 {
+			return resources, nil
+		}
+
+		var books []*models.AddressBook
+		if token := contextGetToken(r); token != nil {
+			book, err := app.books.Get(token.Book())
+			if err != nil && !errors.Is(err, models.ErrNoRecord) {
+				return nil, err
+			}
+			if book != nil {
+				books = append(books, book)
+			}
+		} else {
+			books = app.books.ForUser(contextGetUser(r).ID)
+		}
+
+		for _, book := range books {
+			resources = append(resources, app.davBookResource(r, book))
+		}
+
+		return resources, nil
+	})
+}
+
+// propfindDAVBook describes an address book and, unless Depth is 0, every contact in it.
+func (app *application) propfindDAVBook(w http.ResponseWriter, r *http.Request) {
+	app.propfindDAV(w, r, func(depth int) ([]davResource, error) {
+		book := contextGetBook(r)
+		resources := []davResource{app.davBookResource(r, book)}
+
+		if depth == 0 {
+			return resources, nil
+		}
+
+		contacts, err := app.bookContacts(r).GetAll()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range contacts {
+			res, err := davContactResource(book.ID, c, "")
+			if err != nil {
+				return nil, err
+			}
+			resources = append(resources, res)
+		}
+
+		return resources, nil
+	})
+}
+
+// propfindDAVContact describes a single contact's vCard resource.
+func (app *application) propfindDAVContact(w http.ResponseWriter, r *http.Request) {
+	contact, ok := app.davContactFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	app.propfindDAV(w, r, func(depth int) ([]davResource, error) {
+		res, err := davContactResource(contextGetBook(r).ID, contact, "")
+		return []davResource{res}, err
+	})
+}
+
+// propfindDAV answers a PROPFIND request with the properties of the resources returned by list
+// for the request's depth. Address data is only included when a prop element asks for it.
+func (app *application) propfindDAV(w http.ResponseWriter, r *http.Request, list func(depth int) ([]davResource, error)) {
+	depth, ok := davDepth(r)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	body, err := readDAVBody(w, r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var propfind davPropfind
+	if body != nil {
+		if err := xml.Unmarshal(body, &propfind); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	resources, err := list(depth)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	ms := &davMultistatus{}
+	for _, res := range resources {
+		ms.Responses = append(ms.Responses, res.response(propfind.Prop, propfind.PropName != nil))
+	}
+
+	app.writeMultistatus(w, r, ms)
+}
+
+// reportDAVBook runs an addressbook-query, addressbook-multiget or sync-collection report on the
+// current address book.
+func (app *application) reportDAVBook(w http.ResponseWriter, r *http.Request) {
+	body, err := readDAVBody(w, r)
+	if err != nil || body == nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(body, &root); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	switch root.XMLName {
+	case xml.Name{Space: cardDAVNS, Local: "addressbook-query"}:
+		var query davAddressbookQuery
+		if err := xml.Unmarshal(body, &query); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		app.davAddressbookQuery(w, r, &query)
+	case xml.Name{Space: cardDAVNS, Local: "addressbook-multiget"}:
+		var multiget davMultiget
+		if err := xml.Unmarshal(body, &multiget); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		app.davMultiget(w, r, &multiget)
+	case xml.Name{Space: davNS, Local: "sync-collection"}:
+		var sync davSyncCollection
+		if err := xml.Unmarshal(body, &sync); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		app.davSyncCollection(w, r, &sync)
+	default:
+		app.davError(w, http.StatusForbidden, davSupportedReport)
+	}
+}
+
+// davAddressbookQuery returns the contacts that pass the query's filter. When a limit is given,
+// the results are cut short and the truncation is reported with a 507 response for the book.
+func (app *application) davAddressbookQuery(w http.ResponseWriter, r *http.Request, query *davAddressbookQuery) {
+	switch err := query.Filter.check(); {
+	case errors.Is(err, errDAVCollation):
+		app.davError(w, http.StatusForbidden, cardSupportedCollation)
+		return
+	case err != nil:
+		app.davError(w, http.StatusForbidden, cardSupportedFilter)
+		return
+	}
+
+	book := contextGetBook(r)
+
+	contacts, err := app.bookContacts(r).GetAll()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	ms := &davMultistatus{}
+	for _, c := range contacts {
+		if !query.Filter.matches(c) {
+			continue
+		}
+
+		if query.Limit != nil && len(ms.Responses) >= query.Limit.NResults {
+			ms.Responses = append(ms.Responses, davResponse{
+				Href:   davBookPath(book.ID),
+				Status: davStatus(http.StatusInsufficientStorage),
+			})
+			break
+		}
+
+		res, err := davContactResource(book.ID, c, query.Prop.addressData())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		ms.Responses = append(ms.Responses, res.response(query.Prop, false))
+	}
+
+	app.writeMultistatus(w, r, ms)
+}
+
+// davMultiget returns the contacts named by the request's hrefs. Hrefs that do not name a contact
+// in the current book get a 404 response.
+func (app *application) davMultiget(w http.ResponseWriter, r *http.Request, multiget *davMultiget) {
+	book := contextGetBook(r)
+	store := app.bookContacts(r)
+
+	ms := &davMultistatus{}
+	for _, href := range multiget.Hrefs {
+		name, ok := davNameFromHref(book.ID, href)
+		if !ok {
+			ms.Responses = append(ms.Responses, davResponse{Href: href, Status: davStatus(http.StatusNotFound)})
+			continue
+		}
+
+		contact, err := findDAVContact(store, name)
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.serverError(w, r, err)
+				return
+			}
+			ms.Responses = append(ms.Responses, davResponse{Href: href, Status: davStatus(http.StatusNotFound)})
+			continue
+		}
+
+		res, err := davContactResource(book.ID, contact, multiget.Prop.addressData())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		ms.Responses = append(ms.Responses, res.response(multiget.Prop, false))
+	}
+
+	app.writeMultistatus(w, r, ms)
+}
+
+// davSyncCollection reports the contacts changed since the state a sync token stands for, or every
+// contact for an initial sync with an empty token, along with a token for the current state.
+// Contacts that were deleted since get a 404 response. The changes are read from the audit log,
+// so a token is invalid once the log no longer reaches back that far.
+func (app *application) davSyncCollection(w http.ResponseWriter, r *http.Request, sync *davSyncCollection) {
+	book := contextGetBook(r)
+	store := app.bookContacts(r)
+
+	after := 0
+	if sync.SyncToken != "" {
+		id, ok := parseDAVSyncToken(sync.SyncToken)
+		if !ok {
+			app.davError(w, http.StatusForbidden, davValidSyncToken)
+			return
+		}
+		after = id
+	}
+
+	// read the log before the contacts, so that a change made in between is reported again next
+	// time rather than missed
+	changes, newest := app.audit.Changes(book.ID, after)
+	if after > newest {
+		app.davError(w, http.StatusForbidden, davValidSyncToken)
+		return
+	}
+
+	var contacts []*models.Contact
+	var gone []string
+
+	if sync.SyncToken == "" {
+		all, err := store.GetAll()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		contacts = all
+	} else {
+		latest := make(map[int]*models.AuditEntry)
+		var ids []int
+		for _, entry := range changes {
+			if _, ok := latest[entry.ContactID]; !ok {
+				ids = append(ids, entry.ContactID)
+			}
+			latest[entry.ContactID] = entry
+		}
+
+		for _, id := range ids {
+			contact, err := store.Get(id)
+			switch {
+			case err == nil:
+				contacts = append(contacts, contact)
+			case errors.Is(err, models.ErrNoRecord):
+				gone = append(gone, davResourceName(&models.Contact{ID: id, UID: latest[id].ContactUID}))
+			default:
+				app.serverError(w, r, err)
+				return
+			}
+		}
+	}
+
+	if sync.Limit != nil && len(contacts)+len(gone) > sync.Limit.NResults {
+		app.davError(w, http.StatusInsufficientStorage, davMatchesWithinLimits)
+		return
+	}
+
+	ms := &davMultistatus{SyncToken: davSyncTokenFor(newest)}
+	for _, c := range contacts {
+		res, err := davContactResource(book.ID, c, sync.Prop.addressData())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		ms.Responses = append(ms.Responses, res.response(sync.Prop, false))
+	}
+	for _, name := range gone {
+		ms.Responses = append(ms.Responses, davResponse{
+			Href:   davContactPath(book.ID, name),
+			Status: davStatus(http.StatusNotFound),
+		})
+	}
+
+	app.writeMultistatus(w, r, ms)
+}
+
+// getDAVContact downloads a contact's vCard, as version 3.0 unless the Accept header asks for 4.0.
+// Conditional requests are answered from the ETag.
+func (app *application) getDAVContact(w http.ResponseWriter, r *http.Request) {
+	contact, ok := app.davContactFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	card, err := davCard(contact, davAcceptVersion(r.Header.Get("Accept")))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", vcard.ContentType)
+	w.Header().Set("ETag", davETag(contact))
+
+	if davETagMatches(r.Header.Get("If-None-Match"), davETag(contact)) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(card)))
+	w.Write(card)
+}
+
+// putDAVContact creates or replaces a contact from a single vCard. New contacts keep the resource
+// name as their UID. The card is validated like the contact form; only the name, one phone number
+// and one email address are stored, so no ETag is returned and clients fetch the card again.
+func (app *application) putDAVContact(w http.ResponseWriter, r *http.Request) {
+	name, ok := davNameFromFile(r.PathValue("resource"))
+	if !ok {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	store := app.bookContacts(r)
+
+	contact, err := findDAVContact(store, name)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !davPreconditionsHold(r, contact) {
+		app.clientError(w, http.StatusPreconditionFailed)
+		return
+	}
+
+	// contact-<id> names belong to contacts created elsewhere and cannot be reused
+	if contact == nil && davLegacyNameRX.MatchString(name) {
+		app.clientError(w, http.StatusConflict)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "text/vcard" && mediaType != "text/x-vcard" {
+		app.davError(w, http.StatusForbidden, cardSupportedAddressData)
+		return
+	}
+
+	cards, err := vcard.Parse(http.MaxBytesReader(w, r.Body, maxDAVBytes))
+	if err != nil || len(cards) != 1 {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.davError(w, http.StatusForbidden, cardMaxResourceSize)
+		} else {
+			app.davError(w, http.StatusForbidden, cardValidAddressData)
+		}
+		return
+	}
+
+	id := 0
+	if contact != nil {
+		id = contact.ID
+	}
+
+	form := models.ContactForm{
+		ID:    id,
+		First: cards[0].First,
+		Last:  cards[0].Last,
+		Phone: cards[0].Phone,
+		Email: cards[0].Email,
+	}
+
+	validateContactForm(&form, store, id)
+
+	if !form.Valid() {
+		app.davError(w, http.StatusForbidden, cardValidAddressData)
+		return
+	}
+
+	status := http.StatusNoContent
+	if contact == nil {
+		contact = &models.Contact{UID: name}
+		status = http.StatusCreated
+	}
+
+	contact.First = form.First
+	contact.Last = form.Last
+	contact.Phone = form.Phone
+	contact.Email = form.Email
+
+	if status == http.StatusCreated {
+		err = store.Insert(contact)
+	} else {
+		err = store.Update(contact)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateEmail):
+			app.davError(w, http.StatusForbidden, cardValidAddressData)
+		case errors.Is(err, models.ErrNoRecord):
+			app.clientError(w, http.StatusNotFound)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(status)
+}
+
+// deleteDAVContact moves a contact to the trash, like deleting it anywhere else.
+func (app *application) deleteDAVContact(w http.ResponseWriter, r *http.Request) {
+	contact, ok := app.davContactFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if !davPreconditionsHold(r, contact) {
+		app.clientError(w, http.StatusPreconditionFailed)
+		return
+	}
+
+	err := app.bookContacts(r).Delete(contact.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// davContactFromPath loads the contact named by the {resource} path value. If it cannot be loaded
+// an error response has already been written and ok is false.
+func (app *application) davContactFromPath(w http.ResponseWriter, r *http.Request) (*models.Contact, bool) {
+	name, ok := davNameFromFile(r.PathValue("resource"))
+	if !ok {
+		app.clientError(w, http.StatusNotFound)
+		return nil, false
+	}
+
+	contact, err := findDAVContact(app.bookContacts(r), name)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+
+	return contact, true
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"github.com/code-chimp/htmx-go-example/internal/services"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// defaultTokensFile is where API tokens are kept unless -tokens or CONTACTS_TOKENS says otherwise.
+const defaultTokensFile = "./data/tokens.json"
+
+// defaultUsersFile is where user accounts are kept unless -users or CONTACTS_USERS says otherwise.
+const defaultUsersFile = "./data/users.json"
+
+// defaultBooksFile is where address books are kept unless -books or CONTACTS_BOOKS says otherwise.
+const defaultBooksFile = "./data/books.json"
+
+// runTokenCommand implements the "token" subcommand used to manage API tokens:
+//
+//	web token create -name NAME [-scopes contacts:read,contacts:write] [-book ID]
+//	web token list
+//	web token revoke -id ID
+func runTokenCommand(args []string, stdout io.Writer) error {
+	usage := errors.New("usage: web token <create|list|revoke> [flags]")
+
+	if len(args) == 0 {
+		return usage
+	}
+
+	fs := flag.NewFlagSet("token "+args[0], flag.ContinueOnError)
+	tokensPath := fs.String("tokens", envOrDefault("CONTACTS_TOKENS", defaultTokensFile), "JSON file holding API tokens (env CONTACTS_TOKENS)")
+
+	switch args[0] {
+	case "create":
+		name := fs.String("name", "", "Name describing who or what uses the token (required)")
+		scopes := fs.String("scopes", models.ScopeContactsRead, "Comma separated scopes: "+strings.Join(models.Scopes, ", "))
+		book := fs.Int("book", models.DefaultBookID, "ID of the address book the token works on")
+		booksPath := fs.String("books", envOrDefault("CONTACTS_BOOKS", defaultBooksFile), "JSON file holding address books (env CONTACTS_BOOKS)")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if strings.TrimSpace(*name) == "" {
+			return errors.New("token create: -name is required")
+		}
+
+		books, err := services.OpenBookStore(*booksPath)
+		if err != nil {
+			return err
+		}
+
+		if _, err := books.Get(*book); err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				return fmt.Errorf("token create: no address book with id %d", *book)
+			}
+			return err
+		}
+
+		store, err := services.OpenTokenStore(*tokensPath)
+		if err != nil {
+			return err
+		}
+
+		plaintext, token, err := store.Create(strings.TrimSpace(*name), splitScopes(*scopes), *book)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(stdout, "Created token %d (%s) for address book %d with scopes %s\n", token.ID, token.Name, token.Book(), strings.Join(token.Scopes, ", "))
+		fmt.Fprintf(stdout, "\n\t%s\n\nStore it now, it will not be shown again.\n", plaintext)
+
+	case "list":
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		store, err := services.OpenTokenStore(*tokensPath)
+		if err != nil {
+			return err
+		}
+
+		tokens, err := store.All()
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tBOOK\tSCOPES\tCREATED")
+		for _, t := range tokens {
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\n", t.ID, t.Name, t.Book(), strings.Join(t.Scopes, ","), humanDate(t.CreatedAt))
+		}
+		return tw.Flush()
+
+	case "revoke":
+		id := fs.Int("id", 0, "ID of the token to revoke (required)")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		store, err := services.OpenTokenStore(*tokensPath)
+		if err != nil {
+			return err
+		}
+
+		if err := store.Revoke(*id); err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				return fmt.Errorf("token revoke: no token with id %d", *id)
+			}
+			return err
+		}
+
+		fmt.Fprintf(stdout, "Revoked token %d\n", *id)
+
+	default:
+		return usage
+	}
+
+	return nil
+}
+
+// runUserCommand implements the "user" subcommand used to manage user roles:
+//
+//	web user list
+//	web user role -email EMAIL -role viewer|editor|admin
+func runUserCommand(args []string, stdout io.Writer) error {
+	usage := errors.New("usage: web user <list|role> [flags]")
+
+	if len(args) == 0 {
+		return usage
+	}
+
+	fs := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	usersPath := fs.String("users", envOrDefault("CONTACTS_USERS", defaultUsersFile), "JSON file holding user accounts (env CONTACTS_USERS)")
+
+	switch args[0] {
+	case "list":
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		store, err := services.OpenUserStore(*usersPath)
+		if err != nil {
+			return err
+		}
+
+		users, err := store.All()
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tEMAIL\tROLE\tCREATED")
+		for _, u := range users {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", u.ID, u.Name, u.Email, u.EffectiveRole(), humanDate(u.CreatedAt))
+		}
+		return tw.Flush()
+
+	case "role":
+		email := fs.String("email", "", "Email address of the user (required)")
+		role := fs.String("role", "", "New role: "+strings.Join(models.Roles, ", ")+" (required)")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if strings.TrimSpace(*email) == "" || *role == "" {
+			return errors.New("user role: -email and -role are required")
+		}
+
+		store, err := services.OpenUserStore(*usersPath)
+		if err != nil {
+			return err
+		}
+
+		if err := store.SetRole(strings.TrimSpace(*email), *role); err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				return fmt.Errorf("user role: no user with email %s", *email)
+			}
+			return err
+		}
+
+		fmt.Fprintf(stdout, "%s is now %s\n", *email, *role)
+
+	default:
+		return usage
+	}
+
+	return nil
+}
+
+// splitScopes parses a comma separated list of scopes, ignoring blanks.
+func splitScopes(value string) []string {
+	var scopes []string
+	for _, scope := range strings.Split(value, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"net/http"
+)
+
+// contextKey is the type of the keys used to store request-scoped values in a context.
+type contextKey string
+
+const (
+	bookContextKey      = contextKey("book")
+	requestIDContextKey = contextKey("requestID")
+	tokenContextKey     = contextKey("token")
+	userContextKey      = contextKey("user")
+)
+
+// contextSetToken returns a copy of the request with the authenticated API token attached.
+func contextSetToken(r *http.Request, token *models.Token) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), tokenContextKey, token))
+}
+
+// contextGetToken returns the API token that authenticated the request, or nil if there was none.
+func contextGetToken(r *http.Request) *models.Token {
+	token, _ := r.Context().Value(tokenContextKey).(*models.Token)
+	return token
+}
+
+// contextSetUser returns a copy of the request with the signed in user attached.
+func contextSetUser(r *http.Request, user *models.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+}
+
+// contextGetUser returns the signed in user, or nil if the request is anonymous.
+func contextGetUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userContextKey).(*models.User)
+	return user
+}
+
+// contextSetBook returns a copy of the request with the current address book attached.
+func contextSetBook(r *http.Request, book *models.AddressBook) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), bookContextKey, book))
+}
+
+// contextGetBook returns the address book the request works on, or nil if selectBook has not run.
+func contextGetBook(r *http.Request) *models.AddressBook {
+	book, _ := r.Context().Value(bookContextKey).(*models.AddressBook)
+	return book
+}
+
+// contextSetRequestID returns a copy of the request with its request ID attached.
+func contextSetRequestID(r *http.Request, id string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestIDContextKey, id))
+}
+
+// contextGetRequestID returns the ID assigned to the request by the requestID middleware.
+func contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
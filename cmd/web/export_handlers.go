@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"github.com/code-chimp/htmx-go-example/internal/services"
+	"github.com/code-chimp/htmx-go-example/internal/vcard"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// exportFlushEvery is how many contacts are written between checkpoints of a streamed export, where
+// downloads flush the response and archive jobs report their progress.
+const exportFlushEvery = 100
+
+// exportFormats maps the formats the contact list can be exported as to their content type.
+var exportFormats = map[string]string{
+	"csv":  "text/csv; charset=utf-8",
+	"json": "application/json",
+	"vcf":  vcard.ContentType,
+}
+
+// getExportContacts downloads every contact matching the search as CSV, JSON or vCard, in the same
+// order as the contact list. The q and sort parameters mean the same as on the index page and
+// format chooses the output, defaulting to CSV. Contacts are written to the response as they are
+// encoded rather than built up in memory first.
+func (app *application) getExportContacts(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+
+	format := values.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	contentType, ok := exportFormats[format]
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := services.ListParams{Query: values.Get("q")}
+	if sort := values.Get("sort"); services.ValidSort(sort) {
+		params.Sort = sort
+	}
+
+	contacts, _, err := app.bookContacts(r).List(params)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	filename := fmt.Sprintf("contacts-%s.%s", time.Now().Format("2006-01-02"), format)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Cache-Control", "no-store")
+
+	rc := http.NewResponseController(w)
+	flush := func(int) error { return rc.Flush() }
+
+	switch format {
+	case "json":
+		err = streamContactsJSON(w, contacts, flush)
+	case "vcf":
+		err = streamContactsVCard(w, contacts, flush)
+	default:
+		err = streamContactsCSV(w, contacts, flush)
+	}
+
+	// the status line has already been sent, so all that is left is to log the failure
+	if err != nil {
+		app.logger.Error("exporting contacts failed", "error", err.Error(), "request_id", contextGetRequestID(r))
+	}
+}
+
+// getContactVCard downloads a single contact as a vCard. name is the contact ID taken from the
+// /contacts/{id}.vcf path.
+func (app *application) getContactVCard(w http.ResponseWriter, r *http.Request, name string) {
+	id, err := strconv.Atoi(name)
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	contact, err := app.bookContacts(r).Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	filename := fmt.Sprintf("contact-%d.vcf", contact.ID)
+
+	w.Header().Set("Content-Type", vcard.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	if err := vcard.Write(w, contact); err != nil {
+		app.logger.Error("writing vCard failed", "error", err.Error(), "request_id", contextGetRequestID(r))
+	}
+}
+
+// exportColumns lists the CSV export columns. The headers are the ones the CSV import recognizes,
+// so an export can be imported again as is.
+var exportColumns = []struct {
+	header string
+	get    func(*models.Contact) string
+}{
+	{"First Name", func(c *models.Contact) string { return c.First }},
+	{"Last Name", func(c *models.Contact) string { return c.Last }},
+	{"Phone", func(c *models.Contact) string { return c.Phone }},
+	{"Email", func(c *models.Contact) string { return c.Email }},
+}
+
+// streamContactsCSV writes the contacts to w as CSV with a header row, calling checkpoint with the
+// number of contacts written so far after every exportFlushEvery rows.
+func streamContactsCSV(w io.Writer, contacts []*models.Contact, checkpoint func(written int) error) error {
+	cw := csv.NewWriter(w)
+
+	record := make([]string, len(exportColumns))
+	for i, col := range exportColumns {
+		record[i] = col.header
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+
+	for i, c := range contacts {
+		for j, col := range exportColumns {
+			record[j] = col.get(c)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+
+		if (i+1)%exportFlushEvery == 0 {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			if err := checkpoint(i + 1); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// streamContactsJSON writes the contacts to w as a JSON array one element at a time, calling
+// checkpoint like streamContactsCSV.
+func streamContactsJSON(w io.Writer, contacts []*models.Contact, checkpoint func(written int) error) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	for i, c := range contacts {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+
+		if (i+1)%exportFlushEvery == 0 {
+			if err := checkpoint(i + 1); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, "]\n")
+	return err
+}
+
+// streamContactsVCard writes the contacts to w as consecutive vCards, calling checkpoint like
+// streamContactsCSV.
+func streamContactsVCard(w io.Writer, contacts []*models.Contact, checkpoint func(written int) error) error {
+	for i, c := range contacts {
+		if err := vcard.Write(w, c); err != nil {
+			return err
+		}
+
+		if (i+1)%exportFlushEvery == 0 {
+			if err := checkpoint(i + 1); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -6,8 +6,19 @@ import (
 	"github.com/code-chimp/htmx-go-example/internal/models"
 	"github.com/code-chimp/htmx-go-example/internal/services"
 	"github.com/code-chimp/htmx-go-example/internal/validator"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+)
+
+const (
+	// defaultPageSize is the number of contacts shown per page when no size is requested.
+	defaultPageSize = 10
+
+	// maxPageSize caps the page size a client may request.
+	maxPageSize = 100
 )
 
 // getHome is a temporary handler to redirect users to the /contacts page.
@@ -15,28 +26,49 @@ func (app *application) getHome(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/contacts", http.StatusSeeOther)
 }
 
-// getContacts displays the contacts page.
+// getContacts displays a page of contacts.
 func (app *application) getContacts(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("q")
-
-	contacts, err := app.contacts.GetAll(query)
+	data, err := app.contactsIndex(r, r.URL.Query())
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	app.render(w, r, http.StatusOK, "contacts.index.go.tmpl", models.ContactsIndexVM{Contacts: contacts, Query: query})
+	// the response differs depending on which element triggered the request, so caches must key on it
+	w.Header().Add("Vary", "HX-Request, HX-Trigger")
+
+	if isHTMXRequest(r) {
+		switch r.Header.Get("HX-Trigger") {
+		case "search":
+			// active search replaces the rows and, out of band, the pager
+			app.renderPartial(w, r, http.StatusOK, "contacts.index.go.tmpl", "contact-search-results", data)
+			return
+		case "load-more":
+			// load more replaces its own row with the next page of rows
+			app.renderPartial(w, r, http.StatusOK, "contacts.index.go.tmpl", "contact-rows", data)
+			return
+		}
+	}
+
+	app.renderNegotiated(w, r, http.StatusOK, "contacts.index.go.tmpl", data)
 }
 
-// getContact displays a specific contact based on its ID.
+// getContact displays a specific contact based on its ID. Requests for /contacts/{id}.vcf download
+// the contact as a vCard instead.
 func (app *application) getContact(w http.ResponseWriter, r *http.Request) {
+	// a path segment cannot hold both a wildcard and a literal, so /contacts/{id}.vcf lands here too
+	if name, ok := strings.CutSuffix(r.PathValue("id"), ".vcf"); ok {
+		app.getContactVCard(w, r, name)
+		return
+	}
+
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil || id < 1 {
 		app.clientError(w, http.StatusNotFound)
 		return
 	}
 
-	contact, err := app.contacts.Get(id)
+	contact, err := app.bookContacts(r).Get(id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			http.NotFound(w, r)
@@ -46,7 +78,37 @@ func (app *application) getContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	app.render(w, r, http.StatusOK, "contacts.view.go.tmpl", models.ContactsViewVM{Contact: contact})
+	app.renderNegotiated(w, r, http.StatusOK, "contacts.view.go.tmpl", models.ContactsViewVM{Contact: contact})
+}
+
+// getContactHistory displays the audit trail of a contact, newest change first. The history of a
+// deleted contact remains available as long as it has entries.
+func (app *application) getContactHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	data := models.ContactHistoryVM{
+		ContactID: id,
+		Entries:   app.audit.History(contextGetBook(r).ID, id),
+	}
+
+	contact, err := app.bookContacts(r).Get(id)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if contact == nil && len(data.Entries) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	data.Contact = contact
+
+	app.render(w, r, http.StatusOK, "contacts.history.go.tmpl", data)
 }
 
 // getNewContact displays the form for creating a new contact.
@@ -64,7 +126,7 @@ func (app *application) postNewContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	validateContactForm(&form, app.contacts, 0)
+	validateContactForm(&form, app.bookContacts(r), 0)
 
 	if !form.Valid() {
 		app.render(w, r, http.StatusUnprocessableEntity, "contacts.new.go.tmpl", form)
@@ -78,9 +140,14 @@ func (app *application) postNewContact(w http.ResponseWriter, r *http.Request) {
 		Email: form.Email,
 	}
 
-	err = app.contacts.Insert(&contact)
+	err = app.bookContacts(r).Insert(&contact)
 	if err != nil {
-		app.serverError(w, r, err)
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddError("Email", "Email is already in use.")
+			app.render(w, r, http.StatusUnprocessableEntity, "contacts.new.go.tmpl", form)
+		} else {
+			app.serverError(w, r, err)
+		}
 		return
 	}
 
@@ -95,7 +162,7 @@ func (app *application) getEditContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	contact, err := app.contacts.Get(id)
+	contact, err := app.bookContacts(r).Get(id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			http.NotFound(w, r)
@@ -134,14 +201,14 @@ func (app *application) postEditContact(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	validateContactForm(&form, app.contacts, id)
+	validateContactForm(&form, app.bookContacts(r), id)
 
 	if !form.Valid() {
 		app.render(w, r, http.StatusUnprocessableEntity, "contacts.edit.go.tmpl", form)
 		return
 	}
 
-	contact, err := app.contacts.Get(id)
+	contact, err := app.bookContacts(r).Get(id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			http.NotFound(w, r)
@@ -156,16 +223,55 @@ func (app *application) postEditContact(w http.ResponseWriter, r *http.Request)
 	contact.Phone = form.Phone
 	contact.Email = form.Email
 
-	err = app.contacts.Update(contact)
+	err = app.bookContacts(r).Update(contact)
 	if err != nil {
-		app.serverError(w, r, err)
+		switch {
+		case errors.Is(err, models.ErrDuplicateEmail):
+			form.AddError("Email", "Email is already in use.")
+			app.render(w, r, http.StatusUnprocessableEntity, "contacts.edit.go.tmpl", form)
+		case errors.Is(err, models.ErrNoRecord):
+			http.NotFound(w, r)
+		default:
+			app.serverError(w, r, err)
+		}
 		return
 	}
 
 	http.Redirect(w, r, fmt.Sprintf("/contacts/%d", contact.ID), http.StatusSeeOther)
 }
 
-// deleteContact deletes a specific contact based on its ID.
+// getValidateContactEmail validates just the email field of the new or edit contact form with the
+// same rules used on submit, returning only the field's error span so htmx can validate on blur.
+func (app *application) getValidateContactEmail(w http.ResponseWriter, r *http.Request) {
+	id := 0
+
+	// the new contact form is served from /contacts/new/email, which has no id
+	if value := r.PathValue("id"); value != "" {
+		var err error
+
+		id, err = strconv.Atoi(value)
+		if err != nil || id < 1 {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+	}
+
+	form := models.ContactForm{
+		ID:    id,
+		Email: r.URL.Query().Get("email"),
+	}
+
+	validateContactForm(&form, app.bookContacts(r), id)
+
+	// only the email error is relevant here, the other fields were not submitted
+	form.Errors = map[string]string{"Email": form.Errors["Email"]}
+
+	app.renderPartial(w, r, http.StatusOK, "contacts.edit.go.tmpl", "email-error", form)
+}
+
+// deleteContact moves a specific contact to the trash based on its ID. It serves both DELETE /contacts/{id},
+// issued by htmx, and the POST /contacts/{id}/delete form fallback. htmx callers are told to
+// navigate with an HX-Location header; plain browsers get a 303 redirect.
 func (app *application) deleteContact(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil || id < 1 {
@@ -173,17 +279,102 @@ func (app *application) deleteContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.contacts.Delete(id)
+	err = app.bookContacts(r).Delete(id)
 	if err != nil {
-		app.serverError(w, r, err)
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), flashKey, "The contact was moved to the trash.")
+
+	if isHTMXRequest(r) {
+		w.Header().Set("HX-Location", "/contacts")
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
 	http.Redirect(w, r, "/contacts", http.StatusSeeOther)
 }
 
+// postBulkDeleteContacts moves every selected contact to the trash in a single repository write. htmx callers
+// receive the refreshed table rows and pager; everyone else is redirected back to the list.
+func (app *application) postBulkDeleteContacts(w http.ResponseWriter, r *http.Request) {
+	form := models.ContactsBulkDeleteForm{}
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	_, err = app.bookContacts(r).DeleteMany(form.IDs)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// the form carries the current search, sort and paging state so the list can be redrawn as it was
+	data, err := app.contactsIndex(r, r.PostForm)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if isHTMXRequest(r) {
+		app.renderPartial(w, r, http.StatusOK, "contacts.index.go.tmpl", "contact-search-results", data)
+		return
+	}
+
+	http.Redirect(w, r, data.PageURL(data.Page), http.StatusSeeOther)
+}
+
+// contactsIndex builds the contact list view model for the current address book from the q, page,
+// size, sort and scroll parameters. A page past the end of the list is replaced by the last page.
+func (app *application) contactsIndex(r *http.Request, values url.Values) (models.ContactsIndexVM, error) {
+	data := models.ContactsIndexVM{
+		Query:    values.Get("q"),
+		Page:     queryInt(values, "page", 1, 1, math.MaxInt32),
+		Size:     queryInt(values, "size", defaultPageSize, 1, maxPageSize),
+		Infinite: values.Get("scroll") == "infinite",
+	}
+
+	if sort := values.Get("sort"); services.ValidSort(sort) {
+		data.Sort = sort
+	}
+
+	for {
+		contacts, total, err := app.bookContacts(r).List(services.ListParams{
+			Query: data.Query,
+			Page:  data.Page,
+			Size:  data.Size,
+			Sort:  data.Sort,
+		})
+		if err != nil {
+			return data, err
+		}
+
+		// an empty page should encode as [] rather than null for JSON clients
+		if contacts == nil {
+			contacts = []*models.Contact{}
+		}
+
+		data.Contacts = contacts
+		data.Total = total
+
+		if data.Page <= data.TotalPages() {
+			return data, nil
+		}
+
+		data.Page = data.TotalPages()
+	}
+}
+
 // validateContactForm validates the contact form fields.
-func validateContactForm(form *models.ContactForm, repo *services.ContactRepository, id int) {
+func validateContactForm(form *models.ContactForm, repo services.ContactStore, id int) {
 	form.CheckField(validator.NotBlank(form.Email), "Email", "Email is required.")
 	form.CheckField(repo.EmailUnique(form.Email, id), "Email", "Email is already in use.")
 	form.CheckField(validator.NotBlank(form.First), "First", "First name is required.")
@@ -2,13 +2,25 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"github.com/code-chimp/htmx-go-example/internal/services"
 	"github.com/go-playground/form/v4"
+	"github.com/justinas/nosurf"
+	"html/template"
+	"io"
 	"net/http"
+	"net/url"
 	"runtime/debug"
+	"strconv"
+	"strings"
 )
 
+// maxJSONBytes limits the size of JSON request bodies.
+const maxJSONBytes = 1_048_576
+
 // serverError logs the error and sends a generic 500 Internal Server Error response to the user.
 func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
 	var (
@@ -29,16 +41,83 @@ func (app *application) clientError(w http.ResponseWriter, status int) {
 
 // render is a helper that renders a template with the base template and partials.
 func (app *application) render(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
-	ts, ok := app.templates[name]
+	app.renderTemplate(w, r, status, name, "base", data)
+}
+
+// renderNegotiated renders a page like render, except that clients that prefer JSON, according to
+// their Accept header, receive the view model encoded as JSON instead. It is only used for the
+// contact list and contact pages, whose view models are meant to be read by other programs; forms
+// would lose their validation errors, which are not encoded.
+func (app *application) renderNegotiated(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	w.Header().Add("Vary", "Accept")
+
+	if prefersJSON(r) {
+		err := app.writeJSON(w, status, data, nil)
+		if err != nil {
+			app.serverErrorJSON(w, r, err)
+		}
+		return
+	}
+
+	app.render(w, r, status, name, data)
+}
+
+// prefersJSON reports whether the request's Accept header ranks application/json above HTML.
+// Wildcards count towards HTML so that browsers and clients sending */* keep getting pages.
+func prefersJSON(r *http.Request) bool {
+	var jsonQ, htmlQ float64
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(key, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		case "text/html", "text/*", "*/*":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+
+	return jsonQ > htmlQ
+}
+
+// renderPartial renders a single named template, usually one defined in ui/html/partials, from the
+// page's template set. It is used to answer htmx requests that only need a fragment of the page.
+func (app *application) renderPartial(w http.ResponseWriter, r *http.Request, status int, name, partial string, data any) {
+	app.renderTemplate(w, r, status, name, partial, data)
+}
+
+// renderTemplate executes the named template from the page's template set into a buffer and
+// writes it out only if rendering succeeded.
+func (app *application) renderTemplate(w http.ResponseWriter, r *http.Request, status int, name, tmpl string, data any) {
+	cached, ok := app.templates[name]
 	if !ok {
 		app.serverError(w, r, fmt.Errorf("the template %s does not exist", name))
 		return
 	}
 
+	// the cached set is never executed, so it can always be cloned and bound to this request
+	ts, err := cached.Clone()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	ts.Funcs(app.requestFunctions(r))
+
 	// initialize a buffer to hold a test render
 	buf := new(bytes.Buffer)
 
-	err := ts.ExecuteTemplate(buf, "base", data)
+	err = ts.ExecuteTemplate(buf, tmpl, data)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -50,6 +129,80 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, status in
 	buf.WriteTo(w)
 }
 
+// queryInt reads an integer query string parameter, returning def when it is missing or not a number
+// and clamping it to the range [lo, hi].
+func queryInt(qs url.Values, key string, def, lo, hi int) int {
+	value, err := strconv.Atoi(qs.Get(key))
+	if err != nil {
+		return def
+	}
+
+	return max(lo, min(value, hi))
+}
+
+// bookContacts returns the contact store scoped to the address book the request works on. Every
+// change made through it is recorded in the audit log.
+func (app *application) bookContacts(r *http.Request) services.ContactStore {
+	return app.audit.Wrap(app.contacts.InBook(contextGetBook(r).ID), auditActor(r), contextGetRequestID(r))
+}
+
+// auditActor describes who is making the request, for the audit log.
+func auditActor(r *http.Request) string {
+	if token := contextGetToken(r); token != nil {
+		return fmt.Sprintf("API token %d (%s)", token.ID, token.Name)
+	}
+
+	if user := contextGetUser(r); user != nil {
+		return fmt.Sprintf("%s <%s>", user.Name, user.Email)
+	}
+
+	return "anonymous"
+}
+
+// requestFunctions returns the template functions whose results depend on the current request.
+// They replace the placeholders of the same name registered in templates.go.
+func (app *application) requestFunctions(r *http.Request) template.FuncMap {
+	return template.FuncMap{
+		"currentUser": func() *models.User {
+			return contextGetUser(r)
+		},
+		"isAuthenticated": func() bool {
+			return contextGetUser(r) != nil
+		},
+		"flash": func() string {
+			return app.sessionManager.PopString(r.Context(), flashKey)
+		},
+		"csrfToken": func() string {
+			return nosurf.Token(r)
+		},
+		"can": func(p models.Permission) bool {
+			if token := contextGetToken(r); token != nil {
+				return token.Can(p)
+			}
+			if user := contextGetUser(r); user != nil {
+				return user.CanIn(contextGetBook(r), p)
+			}
+			return p == models.PermissionViewContacts
+		},
+		"currentBook": func() *models.AddressBook {
+			return contextGetBook(r)
+		},
+		"addressBooks": func() []*models.AddressBook {
+			userID := 0
+			if user := contextGetUser(r); user != nil {
+				userID = user.ID
+			}
+			return app.books.ForUser(userID)
+		},
+	}
+}
+
+// isHTMXRequest reports whether the request was issued by htmx rather than a full page load.
+// History restoration requests are treated as full page loads since htmx expects the whole document.
+func isHTMXRequest(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true" && r.Header.Get("HX-History-Restore-Request") != "true"
+}
+
 func (app *application) decodePostForm(r *http.Request, dst any) error {
 	if err := r.ParseForm(); err != nil {
 		return err
@@ -68,3 +221,106 @@ func (app *application) decodePostForm(r *http.Request, dst any) error {
 
 	return nil
 }
+
+// envelope wraps JSON responses in a named top-level object.
+type envelope map[string]any
+
+// writeJSON encodes data as JSON and writes it with the given status code and any extra headers.
+func (app *application) writeJSON(w http.ResponseWriter, status int, data any, headers http.Header) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_, err = w.Write(append(js, '\n'))
+
+	return err
+}
+
+// readJSON decodes a single JSON value from the request body into dst. The body is limited to
+// maxJSONBytes and unknown fields are rejected so clients find out about typos.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var (
+			syntaxError        *json.SyntaxError
+			unmarshalTypeError *json.UnmarshalTypeError
+			maxBytesError      *http.MaxBytesError
+		)
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &unmarshalTypeError):
+			return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		default:
+			return err
+		}
+	}
+
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+// errorJSON sends a JSON error response with the given status code and message.
+func (app *application) errorJSON(w http.ResponseWriter, r *http.Request, status int, message any) {
+	err := app.writeJSON(w, status, envelope{"error": message}, nil)
+	if err != nil {
+		app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// tokenError sends a JSON error to a client using API token authentication. 401 responses carry a
+// WWW-Authenticate challenge so clients know to send a bearer token.
+func (app *application) tokenError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+
+	app.errorJSON(w, r, status, message)
+}
+
+// serverErrorJSON logs the error and sends a generic 500 Internal Server Error JSON response.
+func (app *application) serverErrorJSON(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		method = r.Method
+		uri    = r.URL.RequestURI()
+		trace  = string(debug.Stack())
+	)
+
+	app.logger.Error(err.Error(), "method", method, "uri", uri, "trace", trace)
+
+	app.errorJSON(w, r, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+// failedValidationJSON sends a 422 Unprocessable Entity response listing the validation errors by field.
+func (app *application) failedValidationJSON(w http.ResponseWriter, r *http.Request, errs map[string]string) {
+	err := app.writeJSON(w, http.StatusUnprocessableEntity, envelope{"errors": errs}, nil)
+	if err != nil {
+		app.serverErrorJSON(w, r, err)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"github.com/code-chimp/htmx-go-example/internal/services"
+	"github.com/code-chimp/htmx-go-example/internal/vcard"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxImportBytes is the largest CSV file accepted for import.
+const maxImportBytes = 1 << 20
+
+// importProblem is an error in an uploaded file that is reported back to the user as written.
+type importProblem string
+
+// Error returns the message shown to the user.
+func (p importProblem) Error() string {
+	return string(p)
+}
+
+// importHeaderNames maps normalized CSV header names to the contact field they are guessed to hold.
+var importHeaderNames = map[string]string{
+	"first":        "first",
+	"firstname":    "first",
+	"givenname":    "first",
+	"forename":     "first",
+	"last":         "last",
+	"lastname":     "last",
+	"surname":      "last",
+	"familyname":   "last",
+	"phone":        "phone",
+	"phonenumber":  "phone",
+	"telephone":    "phone",
+	"tel":          "phone",
+	"mobile":       "phone",
+	"email":        "email",
+	"emailaddress": "email",
+	"mail":         "email",
+}
+
+// getImportContacts displays the CSV upload form.
+func (app *application) getImportContacts(w http.ResponseWriter, r *http.Request) {
+	data := models.ContactImportVM{OnDuplicate: models.ImportSkip}
+
+	app.render(w, r, http.StatusOK, "contacts.import.go.tmpl", data)
+}
+
+// postImportContacts previews or performs a CSV import. The file is uploaded once and then carried
+// in a hidden field so that the column mapping and duplicate handling can be changed, each change
+// re-rendering just the preview for htmx callers. Submitting with action=import saves every valid
+// row in a single write.
+func (app *application) postImportContacts(w http.ResponseWriter, r *http.Request) {
+	text, err := readImportCSV(r)
+	if err != nil {
+		var problem importProblem
+		if !errors.As(err, &problem) {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+
+		data := models.ContactImportVM{OnDuplicate: models.ImportSkip}
+		data.AddNonFieldError(problem.Error())
+		app.render(w, r, http.StatusUnprocessableEntity, "contacts.import.go.tmpl", data)
+		return
+	}
+
+	data := models.ContactImportVM{
+		CSV:         text,
+		OnDuplicate: r.PostForm.Get("on_duplicate"),
+	}
+	if !slices.Contains(models.ImportDuplicateModes, data.OnDuplicate) {
+		data.OnDuplicate = models.ImportSkip
+	}
+
+	headers, records, err := parseImportCSV(text)
+	if err != nil {
+		data.CSV = ""
+		data.AddNonFieldError(err.Error())
+		app.render(w, r, http.StatusUnprocessableEntity, "contacts.import.go.tmpl", data)
+		return
+	}
+
+	data.Headers = headers
+	data.Mapping = importMapping(headers, r)
+
+	store := app.bookContacts(r)
+
+	data.Rows, err = buildImportRows(store, data.Mapping, data.OnDuplicate, records)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if r.PostForm.Get("action") != "import" {
+		if isHTMXRequest(r) {
+			app.renderPartial(w, r, http.StatusOK, "contacts.import.go.tmpl", "import-preview", data)
+			return
+		}
+
+		app.render(w, r, http.StatusOK, "contacts.import.go.tmpl", data)
+		return
+	}
+
+	var contacts []*models.Contact
+	for _, row := range data.Rows {
+		if row.Status == models.ImportRowInvalid || row.Status == models.ImportSkip {
+			continue
+		}
+
+		contacts = append(contacts, &models.Contact{
+			ID:    row.ExistingID,
+			First: row.Form.First,
+			Last:  row.Form.Last,
+			Phone: row.Form.Phone,
+			Email: row.Form.Email,
+		})
+	}
+
+	if len(contacts) == 0 {
+		data.AddNonFieldError("There are no valid rows to import.")
+		app.render(w, r, http.StatusUnprocessableEntity, "contacts.import.go.tmpl", data)
+		return
+	}
+
+	err = store.SaveMany(contacts)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			data.AddNonFieldError("Another change was saved while the import was being prepared. Please review the preview and try again.")
+			data.Rows, err = buildImportRows(store, data.Mapping, data.OnDuplicate, records)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			app.render(w, r, http.StatusUnprocessableEntity, "contacts.import.go.tmpl", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	created := data.Count(models.ImportRowNew)
+	updated := len(contacts) - created
+	skipped := len(data.Rows) - len(contacts)
+	app.sessionManager.Put(r.Context(), flashKey, fmt.Sprintf("Import finished: %d created, %d updated, %d skipped.", created, updated, skipped))
+
+	if isHTMXRequest(r) {
+		w.Header().Set("HX-Redirect", "/contacts")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	http.Redirect(w, r, "/contacts", http.StatusSeeOther)
+}
+
+// readImportCSV returns the CSV text of an import request, taken from the uploaded "file" on the
+// first post and from the hidden "csv" field afterwards. An uploaded vCard file is converted to CSV
+// so that it goes through the same preview as a spreadsheet.
+func readImportCSV(r *http.Request) (string, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxImportBytes); err != nil {
+			return "", err
+		}
+
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			if errors.Is(err, http.ErrMissingFile) {
+				return "", importProblem("Please choose a CSV file to import.")
+			}
+			return "", err
+		}
+		defer file.Close()
+
+		b, err := io.ReadAll(io.LimitReader(file, maxImportBytes+1))
+		if err != nil {
+			return "", err
+		}
+		if len(b) > maxImportBytes {
+			return "", importProblem(fmt.Sprintf("The file is larger than %d KB.", maxImportBytes>>10))
+		}
+
+		text := string(bytes.TrimPrefix(b, []byte("\ufeff")))
+		if isVCard(text) {
+			return vcardToCSV(text)
+		}
+
+		return text, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return "", err
+	}
+
+	text := r.PostForm.Get("csv")
+	if text == "" {
+		return "", importProblem("Please choose a CSV file to import.")
+	}
+	if len(text) > maxImportBytes {
+		return "", importProblem(fmt.Sprintf("The file is larger than %d KB.", maxImportBytes>>10))
+	}
+
+	return text, nil
+}
+
+// isVCard reports whether an uploaded file holds vCards rather than CSV.
+func isVCard(text string) bool {
+	first, _, _ := strings.Cut(strings.TrimLeftFunc(text, unicode.IsSpace), "\n")
+	return strings.EqualFold(strings.TrimSpace(first), "BEGIN:VCARD")
+}
+
+// vcardToCSV converts vCard text to CSV with the export columns, which importMapping recognizes.
+func vcardToCSV(text string) (string, error) {
+	contacts, err := vcard.Parse(strings.NewReader(text))
+	if err != nil {
+		return "", importProblem(fmt.Sprintf("The file is not a valid vCard: %v.", strings.TrimPrefix(err.Error(), "vcard: ")))
+	}
+
+	var b strings.Builder
+	if err := streamContactsCSV(&b, contacts, func(int) error { return nil }); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// parseImportCSV splits CSV text into its header row and the records that follow. Records may have
+// more or fewer fields than the header; blank records are dropped.
+func parseImportCSV(text string) ([]string, [][]string, error) {
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	all, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, importProblem(fmt.Sprintf("The file is not valid CSV: %v.", err))
+	}
+
+	if len(all) == 0 {
+		return nil, nil, importProblem("The file is empty.")
+	}
+
+	headers := all[0]
+	for i, h := range headers {
+		headers[i] = strings.TrimSpace(h)
+	}
+
+	var records [][]string
+	for _, rec := range all[1:] {
+		if slices.ContainsFunc(rec, func(v string) bool { return strings.TrimSpace(v) != "" }) {
+			records = append(records, rec)
+		}
+	}
+
+	if len(records) == 0 {
+		return nil, nil, importProblem("The file has a header row but no contacts.")
+	}
+
+	return headers, records, nil
+}
+
+// importMapping returns the CSV column index for each contact field, or -1 for fields that are not
+// imported. Choices posted as map_<field> win; otherwise the column is guessed from its header.
+func importMapping(headers []string, r *http.Request) map[string]int {
+	mapping := make(map[string]int, len(models.ImportFields))
+
+	for _, f := range models.ImportFields {
+		mapping[f.Name] = -1
+
+		if v := r.PostForm.Get("map_" + f.Name); v != "" {
+			if col, err := strconv.Atoi(v); err == nil && col >= -1 && col < len(headers) {
+				mapping[f.Name] = col
+			}
+			continue
+		}
+
+		for i, h := range headers {
+			if importHeaderNames[normalizeHeader(h)] == f.Name {
+				mapping[f.Name] = i
+				break
+			}
+		}
+	}
+
+	return mapping
+}
+
+// normalizeHeader lowercases a CSV header and strips everything but letters, so that "First Name",
+// "first_name" and "FirstName" compare equal.
+func normalizeHeader(h string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, h)
+}
+
+// buildImportRows maps and validates each record the way the contact form would, then decides what
+// the import will do with it. Rows whose email address matches an existing contact are skipped,
+// overwritten or merged according to onDuplicate; a merge keeps the existing value of every field
+// the row leaves blank. An email address repeated within the file is an error.
+func buildImportRows(store services.ContactStore, mapping map[string]int, onDuplicate string, records [][]string) ([]*models.ContactImportRow, error) {
+	existing, err := store.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	byEmail := make(map[string]*models.Contact, len(existing))
+	for _, c := range existing {
+		byEmail[c.Email] = c
+	}
+
+	cell := func(rec []string, field string) string {
+		if col := mapping[field]; col >= 0 && col < len(rec) {
+			return strings.TrimSpace(rec[col])
+		}
+		return ""
+	}
+
+	seen := make(map[string]int)
+	rows := make([]*models.ContactImportRow, 0, len(records))
+
+	for i, rec := range records {
+		row := &models.ContactImportRow{
+			// the header is line 1
+			Line: i + 2,
+			Form: models.ContactForm{
+				First: cell(rec, "first"),
+				Last:  cell(rec, "last"),
+				Phone: cell(rec, "phone"),
+				Email: cell(rec, "email"),
+			},
+			Status: models.ImportRowNew,
+		}
+
+		if match := byEmail[row.Form.Email]; match != nil && row.Form.Email != "" {
+			row.ExistingID = match.ID
+
+			switch onDuplicate {
+			case models.ImportSkip:
+				row.Status = models.ImportSkip
+			case models.ImportMerge:
+				row.Status = models.ImportMerge
+				row.Form.First = cmp.Or(row.Form.First, match.First)
+				row.Form.Last = cmp.Or(row.Form.Last, match.Last)
+				row.Form.Phone = cmp.Or(row.Form.Phone, match.Phone)
+			default:
+				row.Status = models.ImportOverwrite
+			}
+		}
+
+		// skipped rows keep ID 0, so the uniqueness check reports the existing contact
+		if row.Status != models.ImportSkip {
+			row.Form.ID = row.ExistingID
+		}
+		validateContactForm(&row.Form, store, row.Form.ID)
+
+		if line, ok := seen[row.Form.Email]; ok {
+			row.Form.AddError("Email", fmt.Sprintf("Email is repeated from line %d.", line))
+		} else if row.Form.Email != "" {
+			seen[row.Form.Email] = row.Line
+		}
+
+		if row.Status != models.ImportSkip && !row.Form.Valid() {
+			row.Status = models.ImportRowInvalid
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
@@ -1,32 +1,79 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/alexedwards/scs/v2"
 	"github.com/code-chimp/htmx-go-example/internal/services"
 	"github.com/code-chimp/htmx-go-example/internal/vcs"
 	"github.com/go-playground/form/v4"
 	"html/template"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
 const version = "1.0.0"
 
+// shutdownTimeout is how long in-flight requests and background jobs get to finish on shutdown.
+const shutdownTimeout = 30 * time.Second
+
 var revision = vcs.Revision()
 
 // application struct holds the application-wide dependencies.
 type application struct {
-	logger      *slog.Logger
-	contacts    *services.ContactRepository
-	templates   map[string]*template.Template
-	formDecoder *form.Decoder
+	logger         *slog.Logger
+	contacts       services.ContactStore
+	books          *services.BookStore
+	audit          *services.AuditLog
+	archives       *services.ArchiveManager
+	tokens         *services.TokenStore
+	users          *services.UserStore
+	davCredentials *davCredentialCache
+	templates      map[string]*template.Template
+	formDecoder    *form.Decoder
+	sessionManager *scs.SessionManager
+	trashRetention time.Duration
+
+	// wg tracks the background goroutines that must finish before the process exits.
+	wg sync.WaitGroup
 }
 
 func main() {
+	// subcommands are dispatched before the server flags are parsed
+	if len(os.Args) > 1 {
+		commands := map[string]func([]string, io.Writer) error{
+			"token": runTokenCommand,
+			"user":  runUserCommand,
+		}
+
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
+
 	addr := flag.Int("addr", 4000, "HTTP network address")
+	store := flag.String("store", "json", "Contact storage backend (json|memory|sqlite)")
+	dataPath := flag.String("data", envOrDefault("CONTACTS_DATA", services.DefaultContactsFile), "JSON file used by the json store (env CONTACTS_DATA)")
+	dbPath := flag.String("db", envOrDefault("CONTACTS_DB", "./data/contacts.db"), "SQLite database file used by the sqlite store (env CONTACTS_DB)")
+	tokensPath := flag.String("tokens", envOrDefault("CONTACTS_TOKENS", defaultTokensFile), "JSON file holding API tokens (env CONTACTS_TOKENS)")
+	auditPath := flag.String("audit", envOrDefault("CONTACTS_AUDIT", "./data/audit.jsonl"), "JSON Lines file recording every contact change (env CONTACTS_AUDIT)")
+	booksPath := flag.String("books", envOrDefault("CONTACTS_BOOKS", defaultBooksFile), "JSON file holding address books (env CONTACTS_BOOKS)")
+	usersPath := flag.String("users", envOrDefault("CONTACTS_USERS", defaultUsersFile), "JSON file holding user accounts (env CONTACTS_USERS)")
+	archiveTTL := flag.Duration("archive-ttl", time.Hour, "How long a finished contact archive can be downloaded before it is deleted (0 keeps it until cleared)")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "How long deleted contacts stay in the trash before they are purged (0 keeps them until purged by hand)")
+	secureCookies := flag.Bool("secure-cookies", true, "Mark session cookies Secure (disable only for plain HTTP on hosts other than localhost)")
 	displayVersion := flag.Bool("version", false, "Display version information")
 	flag.Parse()
 
@@ -43,7 +90,55 @@ func main() {
 		os.Exit(1)
 	}
 
-	contactRepository, err := services.NewRepository()
+	contactStore, err := openContactStore(*store, *dataPath, *dbPath)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if repo, ok := contactStore.(*services.ContactRepository); ok && repo.RecoveredFrom() != "" {
+		logger.Warn("contacts file was unreadable, restored from backup", slog.String("backup", repo.RecoveredFrom()))
+	}
+
+	auditLog, err := services.OpenAuditLog(*auditPath, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// the history of purged contacts stays in the audit log, so their IDs must not be handed out again
+	if reserver, ok := contactStore.(services.IDReserver); ok {
+		if err := reserver.ReserveIDs(auditLog.LastContactID()); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	bookStore, err := services.OpenBookStore(*booksPath)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	tokenStore, err := services.OpenTokenStore(*tokensPath)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	userStore, err := services.OpenUserStore(*usersPath)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	archiveManager, err := services.NewArchiveManager(*archiveTTL)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	davCredentials, err := newDAVCredentialCache()
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
@@ -51,11 +146,38 @@ func main() {
 
 	formDecoder := form.NewDecoder()
 
+	sessionManager := scs.New()
+	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Cookie.Name = "contacts_session"
+	sessionManager.Cookie.HttpOnly = true
+	sessionManager.Cookie.SameSite = http.SameSiteLaxMode
+	sessionManager.Cookie.Secure = *secureCookies
+
 	app := &application{
-		logger:      logger,
-		contacts:    contactRepository,
-		templates:   templateCache,
-		formDecoder: formDecoder,
+		logger:         logger,
+		contacts:       contactStore,
+		books:          bookStore,
+		audit:          auditLog,
+		archives:       archiveManager,
+		tokens:         tokenStore,
+		users:          userStore,
+		davCredentials: davCredentials,
+		templates:      templateCache,
+		formDecoder:    formDecoder,
+		sessionManager: sessionManager,
+		trashRetention: *trashRetention,
+	}
+
+	// SIGINT or SIGTERM stops the server and every background goroutine started with ctx
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if purger, ok := contactStore.(services.TrashPurger); ok && *trashRetention > 0 {
+		app.wg.Add(1)
+		go func() {
+			defer app.wg.Done()
+			app.purgeTrash(ctx, purger, *trashRetention)
+		}()
 	}
 
 	srv := &http.Server{
@@ -75,7 +197,78 @@ func main() {
 		),
 	)
 
-	err = srv.ListenAndServe()
-	logger.Error(err.Error())
-	os.Exit(1)
+	err = app.serve(ctx, srv)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	if closer, ok := contactStore.(io.Closer); ok {
+		closer.Close()
+	}
+
+	if err != nil {
+		os.Exit(1)
+	}
+
+	logger.Info("stopped server")
+}
+
+// serve runs the server until ctx is cancelled. It then stops accepting connections and waits up
+// to shutdownTimeout for in-flight requests, archive jobs and the other background goroutines to
+// finish. It returns an error only if the server fails or does not shut down cleanly.
+func (app *application) serve(ctx context.Context, srv *http.Server) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	app.logger.Info("shutting down server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(shutdownCtx)
+	if archiveErr := app.archives.Shutdown(shutdownCtx); err == nil {
+		err = archiveErr
+	}
+
+	app.wg.Wait()
+
+	if serveErr := <-errs; !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+
+	return err
+}
+
+// openContactStore creates the contact storage backend selected by the -store flag. Like the
+// sqlite store, the json store creates its file when it does not exist yet, so a fresh path can be
+// given for a new deployment. The sqlite store applies any pending schema migrations before it is
+// returned.
+func openContactStore(kind, dataPath, dbPath string) (services.ContactStore, error) {
+	switch kind {
+	case "json":
+		return services.OpenRepository(services.RepositoryOptions{Path: dataPath, Pretty: true, CreateIfMissing: true})
+	case "memory":
+		return services.NewMemoryStore(nil), nil
+	case "sqlite":
+		return services.OpenSQLiteStore(dbPath)
+	default:
+		return nil, fmt.Errorf("unknown contact store %q", kind)
+	}
+}
+
+// envOrDefault returns the value of the environment variable key, or fallback if it is unset or empty.
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
 }
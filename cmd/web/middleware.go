@@ -1,8 +1,17 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"github.com/justinas/alice"
+	"github.com/justinas/nosurf"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
 // commonHeaders adds some security headers to the response.
@@ -17,6 +26,57 @@ func commonHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// noSurf protects state-changing requests against cross-site request forgery. The token travels in a
+// hidden form field (csrf_token) or, for htmx requests, the X-CSRF-Token header. Requests carrying an
+// Authorization header are exempt: browsers never attach one cross-site, and they are authenticated
+// by authenticateToken instead of the session cookie.
+func (app *application) noSurf(next http.Handler) http.Handler {
+	csrfHandler := nosurf.New(next)
+	csrfHandler.SetBaseCookie(http.Cookie{
+		HttpOnly: true,
+		Path:     "/",
+		Secure:   app.sessionManager.Cookie.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	// the origin check needs to know the scheme the browser used; the app serves plain HTTP itself
+	// but may sit behind a TLS-terminating proxy
+	csrfHandler.SetIsTLSFunc(func(r *http.Request) bool {
+		return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+	})
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") != ""
+	})
+	csrfHandler.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.logger.Warn("CSRF check failed", "reason", nosurf.Reason(r), "method", r.Method, "uri", r.URL.RequestURI())
+		app.clientError(w, http.StatusBadRequest)
+	}))
+
+	return csrfHandler
+}
+
+// requestIDRX matches request IDs accepted from the X-Request-ID header of a proxy or client.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID tags every request with an ID, reusing a well-formed X-Request-ID header and otherwise
+// generating one. The ID is echoed in the response headers, logged and recorded in the audit log.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 8)
+			if _, err := rand.Read(b); err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		next.ServeHTTP(w, contextSetRequestID(r, id))
+	})
+}
+
 // logRequest logs information about the incoming request.
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -25,9 +85,10 @@ func (app *application) logRequest(next http.Handler) http.Handler {
 			proto  = r.Proto
 			method = r.Method
 			uri    = r.URL.RequestURI()
+			id     = contextGetRequestID(r)
 		)
 
-		app.logger.Info("Request", "ip", ip, "proto", proto, "method", method, "uri", uri)
+		app.logger.Info("Request", "ip", ip, "proto", proto, "method", method, "uri", uri, "request_id", id)
 
 		next.ServeHTTP(w, r)
 	})
@@ -47,3 +108,271 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// authenticateToken checks a bearer token sent in the Authorization header. Requests without the
+// header pass through untouched; a valid token is attached to the request context and an invalid
+// one is rejected outright.
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scheme, plaintext, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || plaintext == "" {
+			app.tokenError(w, r, http.StatusUnauthorized, "invalid or missing authentication token")
+			return
+		}
+
+		token, err := app.tokens.Authenticate(strings.TrimSpace(plaintext))
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidToken) {
+				app.tokenError(w, r, http.StatusUnauthorized, "invalid or missing authentication token")
+			} else {
+				app.serverErrorJSON(w, r, err)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, contextSetToken(r, token))
+	})
+}
+
+// requireToken rejects requests that were not authenticated with an API token.
+func (app *application) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contextGetToken(r) == nil {
+			app.tokenError(w, r, http.StatusUnauthorized, "invalid or missing authentication token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requirePermission returns middleware that rejects requests that are not allowed the permission.
+// Token-authenticated requests are checked against the token's scopes and signed in users against
+// their role, or their ownership of the current book. Anonymous visitors may only view contacts;
+// routes that need more must also use requireAuthentication so that they are sent to the login
+// page first. It runs after the book has been selected.
+func (app *application) requirePermission(p models.Permission) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token := contextGetToken(r); token != nil {
+				if !token.Can(p) {
+					app.tokenError(w, r, http.StatusForbidden, fmt.Sprintf("this token does not have the %s scope", p.Scope()))
+					return
+				}
+			} else if user := contextGetUser(r); user != nil {
+				if !user.CanIn(contextGetBook(r), p) {
+					app.clientError(w, http.StatusForbidden)
+					return
+				}
+			} else if p != models.PermissionViewContacts {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticate loads the user whose ID is stored in the session and attaches it to the request
+// context. Sessions pointing at users that no longer exist are treated as anonymous.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := app.sessionManager.GetInt(r.Context(), authenticatedUserIDKey)
+		if id == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.users.Get(id)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.sessionManager.Remove(r.Context(), authenticatedUserIDKey)
+				next.ServeHTTP(w, r)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, contextSetUser(r, user))
+	})
+}
+
+// requireAuthentication sends anonymous visitors to the login page. Requests authenticated with an
+// API token are let through; their scopes are checked by requirePermission.
+func (app *application) requireAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contextGetUser(r) == nil && contextGetToken(r) == nil {
+			// come back to the page after logging in, but never to a form submission
+			if r.Method == http.MethodGet && !isHTMXRequest(r) {
+				app.sessionManager.Put(r.Context(), redirectAfterLoginKey, r.URL.RequestURI())
+			}
+
+			if isHTMXRequest(r) {
+				w.Header().Set("HX-Redirect", "/user/login")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+
+		// pages that need a login must not be cached where another user could see them
+		w.Header().Add("Cache-Control", "no-store")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// selectBook attaches the address book the request works on to the request context. API tokens are
+// tied to a single book. Everyone else works on the book chosen with the header switcher, falling
+// back to the shared default book when nothing was chosen or the choice is no longer accessible.
+func (app *application) selectBook(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := contextGetToken(r); token != nil {
+			book, err := app.books.Get(token.Book())
+			if err != nil {
+				if errors.Is(err, models.ErrNoRecord) {
+					app.tokenError(w, r, http.StatusForbidden, "the address book for this token no longer exists")
+				} else {
+					app.serverError(w, r, err)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, contextSetBook(r, book))
+			return
+		}
+
+		userID := 0
+		if user := contextGetUser(r); user != nil {
+			userID = user.ID
+		}
+
+		if id := app.sessionManager.GetInt(r.Context(), currentBookIDKey); id != 0 {
+			book, err := app.books.Get(id)
+			if err == nil && book.CanAccess(userID) {
+				next.ServeHTTP(w, contextSetBook(r, book))
+				return
+			}
+
+			if err != nil && !errors.Is(err, models.ErrNoRecord) {
+				app.serverError(w, r, err)
+				return
+			}
+
+			app.sessionManager.Remove(r.Context(), currentBookIDKey)
+		}
+
+		book, err := app.books.Get(models.DefaultBookID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, contextSetBook(r, book))
+	})
+}
+
+// requireUser rejects requests authenticated only by an API token. It is used for account pages
+// that act on behalf of a person, such as managing address books, and runs after
+// requireAuthentication.
+func (app *application) requireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contextGetUser(r) == nil {
+			app.tokenError(w, r, http.StatusForbidden, "this action requires a signed in user")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateDAV signs in CardDAV clients, which cannot use the login form and instead send the
+// account's email address and password with HTTP Basic authentication on every request; recent
+// sign ins are remembered by davUser. Bearer tokens are handled by authenticateToken as usual, and
+// wrong credentials are rejected outright.
+func (app *application) authenticateDAV(next http.Handler) http.Handler {
+	bearer := app.authenticateToken(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		email, password, ok := r.BasicAuth()
+		if !ok {
+			bearer.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Authorization")
+
+		user, err := app.davUser(email, password)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				app.davUnauthorized(w)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, contextSetUser(r, user))
+	})
+}
+
+// requireDAVAuthentication asks anonymous CardDAV clients to sign in.
+func (app *application) requireDAVAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contextGetUser(r) == nil && contextGetToken(r) == nil {
+			app.davUnauthorized(w)
+			return
+		}
+
+		w.Header().Add("Cache-Control", "no-store")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// selectDAVBook attaches the address book named by the {book} path value of a CardDAV request to
+// the request context. Books the requester may not access are reported as missing, as are books
+// other than its own for an API token.
+func (app *application) selectDAVBook(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("book"))
+		if err != nil || id < 1 {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		book, err := app.books.Get(id)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.clientError(w, http.StatusNotFound)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		if token := contextGetToken(r); token != nil {
+			if token.Book() != book.ID {
+				app.clientError(w, http.StatusNotFound)
+				return
+			}
+		} else if !book.CanAccess(contextGetUser(r).ID) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		next.ServeHTTP(w, contextSetBook(r, book))
+	})
+}
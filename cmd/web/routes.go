@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/code-chimp/htmx-go-example/internal/models"
 	"github.com/justinas/alice"
 	"net/http"
 )
@@ -8,21 +9,92 @@ import (
 func (app *application) routes() http.Handler {
 	mux := http.NewServeMux()
 
-	dynamic := alice.New()
+	dynamic := alice.New(app.sessionManager.LoadAndSave, app.noSurf, app.authenticate, app.authenticateToken, app.selectBook)
+
+	// anyone may browse contacts; changing them needs a signed in user whose role allows it, or a
+	// token with the write scope
+	protected := dynamic.Append(app.requireAuthentication)
+	viewer := dynamic.Append(app.requirePermission(models.PermissionViewContacts))
+	editor := protected.Append(app.requirePermission(models.PermissionEditContacts))
+	admin := protected.Append(app.requirePermission(models.PermissionDeleteContacts))
+
+	// archives are built for a signed in requester and histories name who made each change, so
+	// neither is open to anonymous visitors
+	reader := protected.Append(app.requirePermission(models.PermissionViewContacts))
+
+	// address books are managed by people, not API tokens
+	account := protected.Append(app.requireUser)
 
 	fileServer := http.FileServer(http.Dir("./ui/static/"))
 	mux.Handle("GET /static/", http.StripPrefix("/static", fileServer))
 
 	mux.Handle("GET /{$}", dynamic.ThenFunc(app.getHome))
-	mux.Handle("GET /contacts", dynamic.ThenFunc(app.getContacts))
-	mux.Handle("GET /contacts/{id}", dynamic.ThenFunc(app.getContact))
-	mux.Handle("GET /contacts/new", dynamic.ThenFunc(app.getNewContact))
-	mux.Handle("POST /contacts/new", dynamic.ThenFunc(app.postNewContact))
-	mux.Handle("GET /contacts/{id}/edit", dynamic.ThenFunc(app.getEditContact))
-	mux.Handle("POST /contacts/{id}/edit", dynamic.ThenFunc(app.postEditContact))
-	mux.Handle("POST /contacts/{id}/delete", dynamic.ThenFunc(app.deleteContact))
-
-	baseMiddlewares := alice.New(app.recoverPanic, app.logRequest, commonHeaders)
+	mux.Handle("GET /contacts", viewer.ThenFunc(app.getContacts))
+	mux.Handle("GET /contacts/export", viewer.ThenFunc(app.getExportContacts))
+	mux.Handle("GET /contacts/archive", reader.ThenFunc(app.getArchive))
+	mux.Handle("POST /contacts/archive", reader.ThenFunc(app.postArchive))
+	mux.Handle("DELETE /contacts/archive", reader.ThenFunc(app.deleteArchive))
+	mux.Handle("GET /contacts/archive/file", reader.ThenFunc(app.getArchiveFile))
+	mux.Handle("GET /contacts/{id}", viewer.ThenFunc(app.getContact))
+	mux.Handle("GET /contacts/new", editor.ThenFunc(app.getNewContact))
+	mux.Handle("POST /contacts/new", editor.ThenFunc(app.postNewContact))
+	mux.Handle("GET /contacts/new/email", editor.ThenFunc(app.getValidateContactEmail))
+	mux.Handle("GET /contacts/import", editor.ThenFunc(app.getImportContacts))
+	mux.Handle("POST /contacts/import", editor.ThenFunc(app.postImportContacts))
+	mux.Handle("POST /contacts/delete", admin.ThenFunc(app.postBulkDeleteContacts))
+	mux.Handle("GET /contacts/{id}/edit", editor.ThenFunc(app.getEditContact))
+	mux.Handle("POST /contacts/{id}/edit", editor.ThenFunc(app.postEditContact))
+	mux.Handle("GET /contacts/{id}/email", editor.ThenFunc(app.getValidateContactEmail))
+	mux.Handle("GET /contacts/{id}/history", reader.ThenFunc(app.getContactHistory))
+	mux.Handle("DELETE /contacts/{id}", admin.ThenFunc(app.deleteContact))
+	mux.Handle("POST /contacts/{id}/delete", admin.ThenFunc(app.deleteContact))
+	mux.Handle("GET /contacts/trash", admin.ThenFunc(app.getTrash))
+	mux.Handle("POST /contacts/{id}/restore", admin.ThenFunc(app.postRestoreContact))
+	mux.Handle("POST /contacts/{id}/purge", admin.ThenFunc(app.postPurgeContact))
+
+	mux.Handle("GET /books", account.ThenFunc(app.getBooks))
+	mux.Handle("POST /books/new", account.ThenFunc(app.postNewBook))
+	mux.Handle("POST /books/switch", account.ThenFunc(app.postSwitchBook))
+	mux.Handle("POST /books/{id}/members", account.ThenFunc(app.postBookMember))
+
+	mux.Handle("GET /user/signup", dynamic.ThenFunc(app.getUserSignup))
+	mux.Handle("POST /user/signup", dynamic.ThenFunc(app.postUserSignup))
+	mux.Handle("GET /user/login", dynamic.ThenFunc(app.getUserLogin))
+	mux.Handle("POST /user/login", dynamic.ThenFunc(app.postUserLogin))
+	mux.Handle("POST /user/logout", protected.ThenFunc(app.postUserLogout))
+
+	// the JSON API is only for programmatic clients, so a token is always required
+	api := alice.New(app.authenticateToken, app.requireToken, app.selectBook)
+	apiViewer := api.Append(app.requirePermission(models.PermissionViewContacts))
+	apiEditor := api.Append(app.requirePermission(models.PermissionEditContacts))
+	apiAdmin := api.Append(app.requirePermission(models.PermissionDeleteContacts))
+
+	mux.Handle("GET /api/v1/contacts", apiViewer.ThenFunc(app.apiListContacts))
+	mux.Handle("POST /api/v1/contacts", apiEditor.ThenFunc(app.apiCreateContact))
+	mux.Handle("GET /api/v1/contacts/{id}", apiViewer.ThenFunc(app.apiGetContact))
+	mux.Handle("PUT /api/v1/contacts/{id}", apiEditor.ThenFunc(app.apiUpdateContact))
+	mux.Handle("DELETE /api/v1/contacts/{id}", apiAdmin.ThenFunc(app.apiDeleteContact))
+
+	// CardDAV clients sign in with HTTP Basic authentication or an API token on every request and
+	// name the address book in the URL instead of keeping a session
+	dav := alice.New(app.authenticateDAV, app.requireDAVAuthentication)
+	davViewer := dav.Append(app.selectDAVBook, app.requirePermission(models.PermissionViewContacts))
+	davEditor := dav.Append(app.selectDAVBook, app.requirePermission(models.PermissionEditContacts))
+	davAdmin := dav.Append(app.selectDAVBook, app.requirePermission(models.PermissionDeleteContacts))
+
+	mux.Handle("/.well-known/carddav", http.RedirectHandler("/carddav/", http.StatusMovedPermanently))
+	mux.HandleFunc("OPTIONS /carddav/", app.davOptions)
+	mux.Handle("PROPFIND /carddav/{$}", dav.ThenFunc(app.propfindDAVRoot))
+	mux.Handle("PROPFIND /carddav/principal/{$}", dav.ThenFunc(app.propfindDAVPrincipal))
+	mux.Handle("PROPFIND /carddav/books/{$}", dav.ThenFunc(app.propfindDAVHome))
+	mux.Handle("PROPFIND /carddav/books/{book}/{$}", davViewer.ThenFunc(app.propfindDAVBook))
+	mux.Handle("REPORT /carddav/books/{book}/{$}", davViewer.ThenFunc(app.reportDAVBook))
+	mux.Handle("PROPFIND /carddav/books/{book}/{resource}", davViewer.ThenFunc(app.propfindDAVContact))
+	mux.Handle("GET /carddav/books/{book}/{resource}", davViewer.ThenFunc(app.getDAVContact))
+	mux.Handle("PUT /carddav/books/{book}/{resource}", davEditor.ThenFunc(app.putDAVContact))
+	mux.Handle("DELETE /carddav/books/{book}/{resource}", davAdmin.ThenFunc(app.deleteDAVContact))
+
+	baseMiddlewares := alice.New(app.recoverPanic, app.requestID, app.logRequest, commonHeaders)
 
 	return baseMiddlewares.Then(mux)
 }
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
 	"github.com/code-chimp/htmx-go-example/ui"
 	"html/template"
 	"io/fs"
@@ -15,10 +18,46 @@ func humanDate(t time.Time) string {
 	return t.Format("02 Jan 2006 at 15:04")
 }
 
-// functions is a map of functions that can be used in templates.
-// It currently includes the humanDate function.
+// add returns the sum of two integers, for simple arithmetic such as page numbers in templates.
+func add(a, b int) int {
+	return a + b
+}
+
+// dict builds a map from alternating key and value arguments so that templates can pass several
+// values to a sub-template.
+func dict(pairs ...any) (map[string]any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict requires an even number of arguments")
+	}
+
+	m := make(map[string]any, len(pairs)/2)
+
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict key %v is not a string", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+
+	return m, nil
+}
+
+// functions is a map of functions that can be used in templates. The request-scoped functions are
+// placeholders so the templates parse; render swaps in real implementations for each request.
 var functions = template.FuncMap{
 	"humanDate": humanDate,
+	"add":       add,
+	"dict":      dict,
+
+	// request-scoped, see application.requestFunctions
+	"currentUser":     func() *models.User { return nil },
+	"isAuthenticated": func() bool { return false },
+	"flash":           func() string { return "" },
+	"csrfToken":       func() string { return "" },
+	"can":             func(models.Permission) bool { return false },
+	"currentBook":     func() *models.AddressBook { return nil },
+	"addressBooks":    func() []*models.AddressBook { return nil },
 }
 
 // newTemplateCache creates a template cache by parsing all .go.tmpl files
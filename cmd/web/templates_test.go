@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"html/template"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// tagRX matches the opening and closing tags that decide whether a button submits a form.
+var tagRX = regexp.MustCompile(`(?s)<(/?)(form|button)\b([^>]*)>`)
+
+// attrRX matches one attribute of a tag, with or without a value.
+var attrRX = regexp.MustCompile(`([\w:-]+)(?:="([^"]*)")?`)
+
+// submittingHTMXButtons returns the htmx buttons in page that the browser would also submit a form
+// with. htmx only cancels the default action of a submit button inside a <form>, so a button
+// outside one must be type="button" and must not name a form with the form attribute.
+func submittingHTMXButtons(page string) []string {
+	var bad []string
+	forms := 0
+
+	for _, m := range tagRX.FindAllStringSubmatch(page, -1) {
+		closing, name, attrs := m[1] == "/", m[2], m[3]
+
+		if name == "form" {
+			if closing {
+				forms--
+			} else {
+				forms++
+			}
+			continue
+		}
+		if closing || forms > 0 {
+			continue
+		}
+
+		values := map[string]string{}
+		for _, a := range attrRX.FindAllStringSubmatch(attrs, -1) {
+			values[a[1]] = a[2]
+		}
+
+		htmx := false
+		for _, verb := range []string{"hx-get", "hx-post", "hx-put", "hx-patch", "hx-delete"} {
+			if _, ok := values[verb]; ok {
+				htmx = true
+			}
+		}
+		_, hasForm := values["form"]
+
+		if htmx && (values["type"] != "button" || hasForm) {
+			bad = append(bad, strings.Join(strings.Fields(m[0]), " "))
+		}
+	}
+
+	return bad
+}
+
+func TestSubmittingHTMXButtons(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want int
+	}{
+		{"type button outside a form", `<button type="button" hx-delete="/x">`, 0},
+		{"submit inside a form", `<form><button hx-post="/x"></button></form>`, 0},
+		{"default type outside a form", `<button hx-delete="/x">`, 1},
+		{"submit naming a form", `<form id="f"></form><button type="submit" form="f" hx-delete="/x">`, 1},
+		{"type button naming a form", `<button type="button" form="f" hx-delete="/x">`, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := submittingHTMXButtons(tt.page); len(got) != tt.want {
+				t.Errorf("got %q; want %d buttons", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditPageDeleteButtonOnlySendsHTMXRequest(t *testing.T) {
+	cache, err := newTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := cache["contacts.edit.go.tmpl"].Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Funcs(template.FuncMap{"can": func(models.Permission) bool { return true }})
+
+	var buf bytes.Buffer
+	if err := ts.ExecuteTemplate(&buf, "body", models.ContactForm{ID: 7}); err != nil {
+		t.Fatal(err)
+	}
+	page := buf.String()
+
+	if !strings.Contains(page, `hx-delete="/contacts/7"`) {
+		t.Fatal("the page has no htmx delete button")
+	}
+
+	// the confirmation dialog only gates the delete if the browser does not submit a form as well
+	if bad := submittingHTMXButtons(page); len(bad) > 0 {
+		t.Errorf("htmx buttons that also submit a form: %q", bad)
+	}
+
+	// browsers without JavaScript still get a working delete button
+	noscript := regexp.MustCompile(`(?s)<noscript>\s*<form id="delete-form" action="/contacts/7/delete" method="post">.*?<button type="submit"`)
+	if !noscript.MatchString(page) {
+		t.Error("the page has no <noscript> fallback form for deleting the contact")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"github.com/code-chimp/htmx-go-example/internal/services"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// getTrash displays the contacts in the current address book's trash.
+func (app *application) getTrash(w http.ResponseWriter, r *http.Request) {
+	contacts, err := app.bookContacts(r).Trash()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := models.ContactsTrashVM{
+		Contacts:  contacts,
+		Retention: app.trashRetention,
+	}
+
+	app.render(w, r, http.StatusOK, "contacts.trash.go.tmpl", data)
+}
+
+// postRestoreContact takes a contact out of the trash. htmx callers receive an empty response that
+// replaces the contact's row; everyone else is redirected back to the trash.
+func (app *application) postRestoreContact(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = app.bookContacts(r).Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			http.NotFound(w, r)
+		case errors.Is(err, models.ErrDuplicateEmail):
+			app.sessionManager.Put(r.Context(), flashKey, "The contact could not be restored because another contact uses its email address.")
+			app.redirectToTrash(w, r)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if isHTMXRequest(r) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), flashKey, "The contact was restored.")
+	app.redirectToTrash(w, r)
+}
+
+// postPurgeContact permanently removes a contact from the trash. It responds like postRestoreContact.
+func (app *application) postPurgeContact(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = app.bookContacts(r).Purge(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if isHTMXRequest(r) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), flashKey, "The contact was permanently deleted.")
+	app.redirectToTrash(w, r)
+}
+
+// redirectToTrash sends the client back to the trash page, using HX-Location for htmx requests.
+func (app *application) redirectToTrash(w http.ResponseWriter, r *http.Request) {
+	if isHTMXRequest(r) {
+		w.Header().Set("HX-Location", "/contacts/trash")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	http.Redirect(w, r, "/contacts/trash", http.StatusSeeOther)
+}
+
+// retentionActor is the actor recorded in the audit log for contacts purged by purgeTrash.
+const retentionActor = "system (trash retention)"
+
+// purgeTrash permanently removes contacts that have been in the trash for longer than the retention
+// period, once straight away and then every hour until ctx is done, and records each one in the
+// audit log. It is meant to run in its own goroutine.
+func (app *application) purgeTrash(ctx context.Context, purger services.TrashPurger, retention time.Duration) {
+	purger = app.audit.WrapPurger(purger, retentionActor)
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		purged, err := purger.PurgeExpired(time.Now().Add(-retention))
+		if err != nil {
+			app.logger.Error("purging the trash failed", "error", err.Error())
+		} else if len(purged) > 0 {
+			app.logger.Info("purged expired contacts from the trash", "count", len(purged))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
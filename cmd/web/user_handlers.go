@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"github.com/code-chimp/htmx-go-example/internal/validator"
+	"net/http"
+	"strings"
+)
+
+// Session keys used for authentication.
+const (
+	authenticatedUserIDKey = "authenticatedUserID"
+	redirectAfterLoginKey  = "redirectPathAfterLogin"
+	flashKey               = "flash"
+)
+
+// getUserSignup displays the signup form.
+func (app *application) getUserSignup(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, http.StatusOK, "user.signup.go.tmpl", models.UserSignupForm{})
+}
+
+// postUserSignup creates a new account and sends the user to the login page.
+func (app *application) postUserSignup(w http.ResponseWriter, r *http.Request) {
+	form := models.UserSignupForm{}
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Name = strings.TrimSpace(form.Name)
+	form.Email = strings.TrimSpace(form.Email)
+
+	form.CheckField(validator.NotBlank(form.Name), "Name", "Name is required.")
+	form.CheckField(validator.NotBlank(form.Email), "Email", "Email is required.")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "Email", "Email must be a valid email address.")
+	form.CheckField(validator.NotBlank(form.Password), "Password", "Password is required.")
+	form.CheckField(validator.MinChars(form.Password, 8), "Password", "Password must be at least 8 characters long.")
+
+	if !form.Valid() {
+		form.Password = ""
+		app.render(w, r, http.StatusUnprocessableEntity, "user.signup.go.tmpl", form)
+		return
+	}
+
+	id, err := app.users.Insert(form.Name, form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddError("Email", "Email address is already in use.")
+			form.Password = ""
+			app.render(w, r, http.StatusUnprocessableEntity, "user.signup.go.tmpl", form)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// every new user gets a private address book of their own
+	_, err = app.books.Create(form.Name+"'s contacts", id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), flashKey, "Your signup was successful. Please log in.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// getUserLogin displays the login form.
+func (app *application) getUserLogin(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, http.StatusOK, "user.login.go.tmpl", models.UserLoginForm{})
+}
+
+// postUserLogin checks the credentials and, if they are valid, signs the user in.
+func (app *application) postUserLogin(w http.ResponseWriter, r *http.Request) {
+	form := models.UserLoginForm{}
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "Email", "Email is required.")
+	form.CheckField(validator.NotBlank(form.Password), "Password", "Password is required.")
+
+	if !form.Valid() {
+		form.Password = ""
+		app.render(w, r, http.StatusUnprocessableEntity, "user.login.go.tmpl", form)
+		return
+	}
+
+	id, err := app.users.Authenticate(strings.TrimSpace(form.Email), form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddNonFieldError("Email or password is incorrect.")
+			form.Password = ""
+			app.render(w, r, http.StatusUnprocessableEntity, "user.login.go.tmpl", form)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// a new session token on privilege change prevents session fixation
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), authenticatedUserIDKey, id)
+
+	path := app.sessionManager.PopString(r.Context(), redirectAfterLoginKey)
+	if path == "" {
+		path = "/contacts"
+	}
+
+	http.Redirect(w, r, path, http.StatusSeeOther)
+}
+
+// postUserLogout signs the user out.
+func (app *application) postUserLogout(w http.ResponseWriter, r *http.Request) {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), authenticatedUserIDKey)
+	app.sessionManager.Remove(r.Context(), currentBookIDKey)
+	app.sessionManager.Put(r.Context(), flashKey, "You've been logged out successfully.")
+
+	http.Redirect(w, r, "/contacts", http.StatusSeeOther)
+}
@@ -3,6 +3,23 @@ module github.com/code-chimp/htmx-go-example
 go 1.23.1
 
 require (
+	github.com/alexedwards/scs/v2 v2.9.0
 	github.com/go-playground/form/v4 v4.2.1
 	github.com/justinas/alice v1.2.0
+	github.com/justinas/nosurf v1.2.0
+	golang.org/x/crypto v0.40.0
+	modernc.org/sqlite v1.39.0
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/exp v0.0.0-20250620022241-b7579e27df2b // indirect
+	golang.org/x/sys v0.34.0 // indirect
+	modernc.org/libc v1.66.3 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.11.0 // indirect
 )
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Archive job states.
+const (
+	ArchiveWaiting  = "waiting"
+	ArchiveRunning  = "running"
+	ArchiveComplete = "complete"
+	ArchiveFailed   = "failed"
+)
+
+// Archive describes a background job that builds a downloadable archive of an address book.
+type Archive struct {
+	Status     string    `json:"status"`
+	Done       int       `json:"done"`
+	Total      int       `json:"total"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Path       string    `json:"-"`
+}
+
+// Progress returns how far along the job is as a percentage from 0 to 100.
+func (a Archive) Progress() int {
+	switch {
+	case a.Status == ArchiveComplete:
+		return 100
+	case a.Total <= 0:
+		return 0
+	default:
+		return min(100, a.Done*100/a.Total)
+	}
+}
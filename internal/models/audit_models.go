@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Audit actions.
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// FieldChange records the value of one contact field before and after a change. Before is empty
+// for a new contact and After is empty for a deleted one.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// AuditEntry records a single change to a contact: who made it, when, as part of which request,
+// and which fields changed. ContactUID keeps the contact's UID, if it has one, so that CardDAV
+// clients can still be told which resource was deleted.
+type AuditEntry struct {
+	ID         int           `json:"id"`
+	Time       time.Time     `json:"time"`
+	Action     string        `json:"action"`
+	ContactID  int           `json:"contact_id"`
+	ContactUID string        `json:"contact_uid,omitempty"`
+	BookID     int           `json:"book_id"`
+	Actor      string        `json:"actor"`
+	RequestID  string        `json:"request_id,omitempty"`
+	Changes    []FieldChange `json:"changes"`
+}
+
+// ContactHistoryVM is the view model for the change history of a contact. Contact is nil once the
+// contact has been deleted.
+type ContactHistoryVM struct {
+	ContactID int
+	Contact   *Contact
+	Entries   []*AuditEntry
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"github.com/code-chimp/htmx-go-example/internal/validator"
+	"slices"
+	"time"
+)
+
+// DefaultBookID identifies the shared address book that every visitor can see. Contacts stored
+// before address books existed belong to it.
+const DefaultBookID = 1
+
+// AddressBook is a separate list of contacts. A book belongs to the user who created it and can be
+// shared with other users by adding them as members. The default book has no owner and is open to
+// everyone.
+type AddressBook struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	OwnerID   int       `json:"owner_id,omitempty"`
+	MemberIDs []int     `json:"member_ids,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Shared reports whether the book is open to every visitor.
+func (b *AddressBook) Shared() bool {
+	return b.OwnerID == 0
+}
+
+// CanAccess reports whether the user with the given ID may work with the book's contacts. Anonymous
+// visitors (ID 0) can only access shared books.
+func (b *AddressBook) CanAccess(userID int) bool {
+	if b.Shared() {
+		return true
+	}
+
+	return userID != 0 && (b.OwnerID == userID || slices.Contains(b.MemberIDs, userID))
+}
+
+// AddressBookForm represents the form for creating an address book.
+type AddressBookForm struct {
+	Name                string `form:"name"`
+	validator.Validator `form:"-"`
+}
+
+// AddressBookMemberForm represents the form for sharing an address book with another user.
+type AddressBookMemberForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+// AddressBooksVM is the view model for the address book management page.
+type AddressBooksVM struct {
+	Books      []*AddressBook
+	Form       AddressBookForm
+	MemberForm AddressBookMemberForm
+	// MemberBookID is the book whose member form failed validation, if any.
+	MemberBookID int
+}
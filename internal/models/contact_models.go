@@ -1,33 +1,154 @@
 package models
 
-import "github.com/code-chimp/htmx-go-example/internal/validator"
+import (
+	"github.com/code-chimp/htmx-go-example/internal/validator"
+	"net/url"
+	"strconv"
+	"time"
+)
 
-// Contact represents a contact persisted to storage.
+// Contact represents a contact persisted to storage. DeletedAt is set while the contact is in the
+// trash. UID identifies a contact created by a CardDAV client; it is set when the contact is
+// inserted and never changes.
 type Contact struct {
-	ID    int    `json:"id"`
-	First string `json:"first"`
-	Last  string `json:"last"`
-	Phone string `json:"phone"`
-	Email string `json:"email"`
+	ID        int        `json:"id"`
+	BookID    int        `json:"book_id"`
+	UID       string     `json:"uid,omitempty"`
+	First     string     `json:"first"`
+	Last      string     `json:"last"`
+	Phone     string     `json:"phone"`
+	Email     string     `json:"email"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
-// ContactsIndexVM represents a view model containing multiple contacts.
+// ContactsIndexVM represents a view model containing one page of contacts.
 type ContactsIndexVM struct {
-	Contacts []*Contact
-	Query    string
+	Contacts []*Contact `json:"contacts"`
+	Query    string     `json:"query"`
+	Page     int        `json:"page"`
+	Size     int        `json:"size"`
+	Total    int        `json:"total"`
+	Sort     string     `json:"sort,omitempty"`
+	Infinite bool       `json:"-"`
+}
+
+// TotalPages returns the number of pages needed to show every matching contact.
+func (vm ContactsIndexVM) TotalPages() int {
+	if vm.Size <= 0 || vm.Total == 0 {
+		return 1
+	}
+
+	return (vm.Total + vm.Size - 1) / vm.Size
+}
+
+// HasPrev reports whether there is a page before the current one.
+func (vm ContactsIndexVM) HasPrev() bool {
+	return vm.Page > 1
+}
+
+// HasNext reports whether there is a page after the current one.
+func (vm ContactsIndexVM) HasNext() bool {
+	return vm.Page < vm.TotalPages()
+}
+
+// PageNumbers returns every page number, for rendering page links.
+func (vm ContactsIndexVM) PageNumbers() []int {
+	pages := make([]int, vm.TotalPages())
+	for i := range pages {
+		pages[i] = i + 1
+	}
+	return pages
+}
+
+// PageURL returns the URL of the given page of the contact list, preserving the current
+// search query, sort order, page size and scroll mode.
+func (vm ContactsIndexVM) PageURL(page int) string {
+	values := url.Values{}
+
+	if vm.Query != "" {
+		values.Set("q", vm.Query)
+	}
+
+	if vm.Sort != "" {
+		values.Set("sort", vm.Sort)
+	}
+
+	if vm.Infinite {
+		values.Set("scroll", "infinite")
+	}
+
+	values.Set("page", strconv.Itoa(page))
+	values.Set("size", strconv.Itoa(vm.Size))
+
+	return "/contacts?" + values.Encode()
+}
+
+// ToggleScrollURL returns the URL of the first page with the scroll mode switched between
+// paged and infinite.
+func (vm ContactsIndexVM) ToggleScrollURL() string {
+	vm.Infinite = !vm.Infinite
+	return vm.PageURL(1)
 }
 
 // ContactsViewVM represents a view model containing a single contact.
 type ContactsViewVM struct {
-	Contact *Contact
+	Contact *Contact `json:"contact"`
 }
 
 // ContactForm represents a form for creating or updating a contact.
 type ContactForm struct {
-	ID                  int    `form:"-"`
-	First               string `form:"first"`
-	Last                string `form:"last"`
-	Phone               string `form:"phone"`
-	Email               string `form:"email"`
-	validator.Validator `form:"-"`
+	ID                  int    `form:"-" json:"-"`
+	First               string `form:"first" json:"first"`
+	Last                string `form:"last" json:"last"`
+	Phone               string `form:"phone" json:"phone"`
+	Email               string `form:"email" json:"email"`
+	validator.Validator `form:"-" json:"-"`
+}
+
+// SortURL returns the URL of the first page sorted by field. Selecting the field the list is
+// already sorted by ascending switches it to descending.
+func (vm ContactsIndexVM) SortURL(field string) string {
+	if vm.Sort == field {
+		vm.Sort = "-" + field
+	} else {
+		vm.Sort = field
+	}
+
+	return vm.PageURL(1)
+}
+
+// SortDirection returns "ascending" or "descending" when the list is sorted by field, or an empty
+// string when it is not. The values match those of the aria-sort attribute.
+func (vm ContactsIndexVM) SortDirection(field string) string {
+	switch vm.Sort {
+	case field:
+		return "ascending"
+	case "-" + field:
+		return "descending"
+	default:
+		return ""
+	}
+}
+
+// ContactsBulkDeleteForm represents a request to delete the selected contacts.
+type ContactsBulkDeleteForm struct {
+	IDs []int `form:"selected_contact_ids"`
+}
+
+// ContactsTrashVM represents a view model containing the contacts in the trash. Retention is how
+// long contacts stay in the trash before they are purged automatically, or zero if they are kept
+// until purged by hand.
+type ContactsTrashVM struct {
+	Contacts  []*Contact
+	Retention time.Duration
+}
+
+// RetentionDays returns the retention period in whole days.
+func (vm ContactsTrashVM) RetentionDays() int {
+	return int(vm.Retention.Hours() / 24)
+}
+
+// PurgeDate returns when a contact in the trash will be purged automatically.
+func (vm ContactsTrashVM) PurgeDate(c *Contact) time.Time {
+	return c.DeletedAt.Add(vm.Retention)
 }
@@ -2,4 +2,10 @@ package models
 
 import "errors"
 
-var ErrNoRecord = errors.New("models: no matching record found")
+var (
+	ErrNoRecord       = errors.New("models: no matching record found")
+	ErrDuplicateEmail = errors.New("models: duplicate email")
+	ErrInvalidToken   = errors.New("models: invalid token")
+
+	ErrInvalidCredentials = errors.New("models: invalid credentials")
+)
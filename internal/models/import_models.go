@@ -0,0 +1,81 @@
+package models
+
+import "github.com/code-chimp/htmx-go-example/internal/validator"
+
+// Ways of handling imported rows whose email address already belongs to a contact.
+const (
+	ImportSkip      = "skip"
+	ImportOverwrite = "overwrite"
+	ImportMerge     = "merge"
+)
+
+// ImportDuplicateModes lists every way of handling duplicate email addresses.
+var ImportDuplicateModes = []string{ImportSkip, ImportOverwrite, ImportMerge}
+
+// Import row statuses. Duplicates take the status of the chosen ImportDuplicateModes value.
+const (
+	ImportRowNew     = "new"
+	ImportRowInvalid = "invalid"
+)
+
+// ImportField describes a contact field that a CSV column can be mapped to.
+type ImportField struct {
+	Name  string
+	Label string
+}
+
+// ImportFields lists the contact fields in the order they are mapped and previewed.
+var ImportFields = []ImportField{
+	{Name: "first", Label: "First Name"},
+	{Name: "last", Label: "Last Name"},
+	{Name: "phone", Label: "Phone"},
+	{Name: "email", Label: "Email"},
+}
+
+// ContactImportRow is one CSV record as it would be imported. Form holds the mapped values and
+// any validation errors; ExistingID is the contact whose email address the row shares, if any.
+type ContactImportRow struct {
+	Line       int
+	Form       ContactForm
+	Status     string
+	ExistingID int
+}
+
+// ContactImportVM represents the CSV import page: the uploaded data, how its columns map to contact
+// fields, what to do with duplicates and the resulting preview.
+type ContactImportVM struct {
+	CSV         string
+	Headers     []string
+	Mapping     map[string]int
+	OnDuplicate string
+	Rows        []*ContactImportRow
+	validator.Validator
+}
+
+// Fields returns the contact fields that CSV columns can be mapped to.
+func (vm ContactImportVM) Fields() []ImportField {
+	return ImportFields
+}
+
+// Mapped reports whether the CSV column with the given index is mapped to field.
+func (vm ContactImportVM) Mapped(field string, column int) bool {
+	col, ok := vm.Mapping[field]
+	return ok && col == column
+}
+
+// Count returns the number of rows with the given status.
+func (vm ContactImportVM) Count(status string) int {
+	n := 0
+	for _, row := range vm.Rows {
+		if row.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Importable returns the number of rows that will be written: new contacts plus overwritten or
+// merged duplicates.
+func (vm ContactImportVM) Importable() int {
+	return vm.Count(ImportRowNew) + vm.Count(ImportOverwrite) + vm.Count(ImportMerge)
+}
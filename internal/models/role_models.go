@@ -0,0 +1,44 @@
+package models
+
+import "slices"
+
+// User roles. Each role includes everything the previous one may do.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Roles lists every role a user may be given, from least to most privileged.
+var Roles = []string{RoleViewer, RoleEditor, RoleAdmin}
+
+// Permission names an operation on contacts that is granted by a role or token scope.
+type Permission string
+
+// Contact permissions.
+const (
+	PermissionViewContacts   Permission = "contacts.view"
+	PermissionEditContacts   Permission = "contacts.edit"
+	PermissionDeleteContacts Permission = "contacts.delete"
+)
+
+// rolePermissions maps each role to the permissions it grants.
+var rolePermissions = map[string][]Permission{
+	RoleViewer: {PermissionViewContacts},
+	RoleEditor: {PermissionViewContacts, PermissionEditContacts},
+	RoleAdmin:  {PermissionViewContacts, PermissionEditContacts, PermissionDeleteContacts},
+}
+
+// RoleCan reports whether role grants the permission. Unknown roles grant nothing.
+func RoleCan(role string, p Permission) bool {
+	return slices.Contains(rolePermissions[role], p)
+}
+
+// Scope returns the API token scope that grants the permission: reading for viewing, writing for
+// any change.
+func (p Permission) Scope() string {
+	if p == PermissionViewContacts {
+		return ScopeContactsRead
+	}
+	return ScopeContactsWrite
+}
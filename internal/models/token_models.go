@@ -0,0 +1,49 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// API token scopes.
+const (
+	ScopeContactsRead  = "contacts:read"
+	ScopeContactsWrite = "contacts:write"
+)
+
+// Scopes lists every scope a token may be granted.
+var Scopes = []string{ScopeContactsRead, ScopeContactsWrite}
+
+// Token represents an API token used by scripts and other services. Only a hash of the secret is
+// stored; the plaintext is shown once when the token is created. A token works on a single address
+// book; tokens without a BookID use the default book.
+type Token struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	BookID    int       `json:"book_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HasScope reports whether the token was granted scope. A write scope implies the matching read scope.
+func (t *Token) HasScope(scope string) bool {
+	if slices.Contains(t.Scopes, scope) {
+		return true
+	}
+
+	return scope == ScopeContactsRead && slices.Contains(t.Scopes, ScopeContactsWrite)
+}
+
+// Can reports whether the token's scopes grant the permission.
+func (t *Token) Can(p Permission) bool {
+	return t.HasScope(p.Scope())
+}
+
+// Book returns the ID of the address book the token works on.
+func (t *Token) Book() int {
+	if t.BookID == 0 {
+		return DefaultBookID
+	}
+	return t.BookID
+}
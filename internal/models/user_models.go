@@ -0,0 +1,56 @@
+package models
+
+import (
+	"github.com/code-chimp/htmx-go-example/internal/validator"
+	"time"
+)
+
+// User represents an account that can sign in to the application. Users without a role are
+// treated as viewers.
+type User struct {
+	ID             int       `json:"id"`
+	Name           string    `json:"name"`
+	Email          string    `json:"email"`
+	HashedPassword []byte    `json:"hashed_password"`
+	Role           string    `json:"role,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// EffectiveRole returns the user's role, defaulting to RoleViewer.
+func (u *User) EffectiveRole() string {
+	if u.Role == "" {
+		return RoleViewer
+	}
+	return u.Role
+}
+
+// Can reports whether the user's role grants the permission.
+func (u *User) Can(p Permission) bool {
+	return RoleCan(u.EffectiveRole(), p)
+}
+
+// CanIn reports whether the user may perform the operation on the contacts of the book. The owner
+// of a book may do anything with it, whatever their role, so that new users can fill their own
+// private book; everywhere else the role decides.
+func (u *User) CanIn(book *AddressBook, p Permission) bool {
+	if book != nil && !book.Shared() && book.OwnerID == u.ID {
+		return true
+	}
+
+	return u.Can(p)
+}
+
+// UserSignupForm represents the form for creating a new account.
+type UserSignupForm struct {
+	Name                string `form:"name"`
+	Email               string `form:"email"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// UserLoginForm represents the form for signing in.
+type UserLoginForm struct {
+	Email               string `form:"email"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrArchiverClosed is returned by ArchiveManager.Start once the manager has been shut down.
+var ErrArchiverClosed = errors.New("services: archive manager is shut down")
+
+// archiveSweepInterval is the longest an expired archive waits before its file is deleted.
+const archiveSweepInterval = time.Minute
+
+// ArchiveBuilder writes an archive to w, reporting its progress as it goes. It should stop and
+// return ctx.Err() once ctx is done.
+type ArchiveBuilder func(ctx context.Context, w io.Writer, progress func(done, total int)) error
+
+// archiveJob is the state of one archive. The job's fields are guarded by the manager's lock.
+type archiveJob struct {
+	archive models.Archive
+	cancel  context.CancelFunc
+}
+
+// ArchiveManager runs the background jobs that build downloadable archives, in the manner of the
+// archiver in Hypermedia Systems. Each key, such as a user and address book pair, has at most one
+// archive at a time; it is built in its own goroutine into a temporary directory and kept there
+// until it is reset, it expires or the manager is shut down. It is safe for concurrent use.
+type ArchiveManager struct {
+	dir    string
+	ttl    time.Duration
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	jobs   map[string]*archiveJob
+	closed bool
+}
+
+// NewArchiveManager creates a manager that keeps its archives in a new temporary directory. Jobs
+// that finished more than ttl ago are forgotten and their archives deleted; a ttl of zero keeps
+// them until they are reset.
+func NewArchiveManager(ttl time.Duration) (*ArchiveManager, error) {
+	dir, err := os.MkdirTemp("", "contacts-archives-")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	m := &ArchiveManager{
+		dir:    dir,
+		ttl:    ttl,
+		ctx:    ctx,
+		cancel: cancel,
+		jobs:   make(map[string]*archiveJob),
+	}
+
+	if ttl > 0 {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.sweep(min(ttl, archiveSweepInterval))
+		}()
+	}
+
+	return m, nil
+}
+
+// sweep removes expired jobs every interval until the manager is shut down.
+func (m *ArchiveManager) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for key, job := range m.jobs {
+				if m.expired(job, now) {
+					m.remove(key, job)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// expired reports whether the job finished more than the manager's ttl before now. Callers must
+// hold the lock.
+func (m *ArchiveManager) expired(job *archiveJob, now time.Time) bool {
+	return m.ttl > 0 && job.archive.Status != models.ArchiveRunning && now.Sub(job.archive.FinishedAt) > m.ttl
+}
+
+// remove cancels the job if it is still running, deletes its archive if it is complete and forgets
+// it. Callers must hold the lock.
+func (m *ArchiveManager) remove(key string, job *archiveJob) {
+	job.cancel()
+	if job.archive.Status == models.ArchiveComplete {
+		os.Remove(job.archive.Path)
+	}
+
+	delete(m.jobs, key)
+}
+
+// job returns the job for key, removing it first if it has expired. Callers must hold the lock.
+func (m *ArchiveManager) job(key string) (*archiveJob, bool) {
+	job, ok := m.jobs[key]
+	if ok && m.expired(job, time.Now()) {
+		m.remove(key, job)
+		return nil, false
+	}
+
+	return job, ok
+}
+
+// Status returns the state of the archive for key. Keys without a job, or whose job has expired,
+// are waiting.
+func (m *ArchiveManager) Status(key string) models.Archive {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job, ok := m.job(key); ok {
+		return job.archive
+	}
+
+	return models.Archive{Status: models.ArchiveWaiting}
+}
+
+// Start begins building the archive for key with build, unless one is already running or complete,
+// and returns its state.
+func (m *ArchiveManager) Start(key string, build ArchiveBuilder) (models.Archive, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return models.Archive{}, ErrArchiverClosed
+	}
+
+	if job, ok := m.job(key); ok && job.archive.Status != models.ArchiveFailed {
+		return job.archive, nil
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	job := &archiveJob{
+		archive: models.Archive{Status: models.ArchiveRunning, StartedAt: time.Now().UTC()},
+		cancel:  cancel,
+	}
+	m.jobs[key] = job
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel()
+		m.run(ctx, job, build)
+	}()
+
+	return job.archive, nil
+}
+
+// run builds one archive into a temporary file and records the outcome on the job. The file is
+// removed again if the build fails or is cancelled.
+func (m *ArchiveManager) run(ctx context.Context, job *archiveJob, build ArchiveBuilder) {
+	progress := func(done, total int) {
+		m.mu.Lock()
+		job.archive.Done, job.archive.Total = done, total
+		m.mu.Unlock()
+	}
+
+	path, err := m.write(ctx, build, progress)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job.archive.FinishedAt = time.Now().UTC()
+
+	switch {
+	case ctx.Err() != nil:
+		// the job was reset or the manager shut down, and nobody is waiting for the result
+		if path != "" {
+			os.Remove(path)
+		}
+	case err != nil:
+		job.archive.Status = models.ArchiveFailed
+		job.archive.Error = err.Error()
+	default:
+		job.archive.Status = models.ArchiveComplete
+		job.archive.Path = path
+	}
+}
+
+// write runs build into a new file in the manager's directory and returns the file's path. The
+// file is removed if build fails.
+func (m *ArchiveManager) write(ctx context.Context, build ArchiveBuilder, progress func(done, total int)) (string, error) {
+	f, err := os.CreateTemp(m.dir, "archive-*")
+	if err != nil {
+		return "", err
+	}
+
+	err = build(ctx, f, progress)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// Reset cancels the archive for key if it is still being built, deletes it if it is complete and
+// returns the key to waiting.
+func (m *ArchiveManager) Reset(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job, ok := m.jobs[key]; ok {
+		m.remove(key, job)
+	}
+}
+
+// Shutdown cancels every running job, waits for them to stop or for ctx to be done, and deletes
+// the archives. Start fails once Shutdown has been called.
+func (m *ArchiveManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+
+	m.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return os.RemoveAll(m.dir)
+}
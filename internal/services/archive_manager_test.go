@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it returns true, failing the test if that takes longer than a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// startTestArchive starts a job for key that writes a short archive, and waits for it to complete.
+func startTestArchive(t *testing.T, m *ArchiveManager, key string) models.Archive {
+	t.Helper()
+
+	build := func(ctx context.Context, w io.Writer, progress func(done, total int)) error {
+		progress(1, 1)
+		_, err := io.WriteString(w, "archive")
+		return err
+	}
+
+	if _, err := m.Start(key, build); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the archive to complete", func() bool {
+		return m.Status(key).Status == models.ArchiveComplete
+	})
+
+	return m.Status(key)
+}
+
+func TestArchiveManagerDeletesExpiredArchives(t *testing.T) {
+	m, err := NewArchiveManager(50 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Shutdown(context.Background())
+
+	archive := startTestArchive(t, m, "swept")
+	if _, err := os.Stat(archive.Path); err != nil {
+		t.Fatalf("archive missing before it expired: %v", err)
+	}
+
+	// the sweeper deletes the file without anyone asking for the job
+	waitFor(t, "the archive to be deleted", func() bool {
+		_, err := os.Stat(archive.Path)
+		return errors.Is(err, os.ErrNotExist)
+	})
+
+	if got := m.Status("swept").Status; got != models.ArchiveWaiting {
+		t.Errorf("got status %q after expiry; want %q", got, models.ArchiveWaiting)
+	}
+}
+
+func TestArchiveManagerKeepsArchivesWithoutTTL(t *testing.T) {
+	m, err := NewArchiveManager(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Shutdown(context.Background())
+
+	archive := startTestArchive(t, m, "kept")
+	time.Sleep(20 * time.Millisecond)
+
+	if got := m.Status("kept"); got.Status != models.ArchiveComplete || got.Path != archive.Path {
+		t.Errorf("got %+v; want the completed archive %s", got, archive.Path)
+	}
+	if _, err := os.Stat(archive.Path); err != nil {
+		t.Errorf("archive was deleted: %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// AuditLog records every change made to contacts. Entries are appended to a JSON Lines file kept
+// alongside the contact data, one entry per line, so that existing history is never rewritten.
+// It is safe for concurrent use.
+//
+// An entry that cannot be written to the file is still kept in memory, so it shows up in History
+// and Changes, and is written together with the next entry. Only a restart before then loses it.
+type AuditLog struct {
+	mu      sync.RWMutex
+	path    string
+	logger  *slog.Logger
+	entries []*models.AuditEntry
+
+	// written is the number of entries that are in the file.
+	written int
+}
+
+// OpenAuditLog loads the entries stored at path. A missing file yields an empty log that is created
+// on the first write, and an empty path keeps the entries in memory only. Changes made through
+// Wrap and WrapPurger whose entries cannot be written are reported to logger, or dropped silently
+// if it is nil.
+//
+// A crash in the middle of a write can leave part of an entry at the end of the file. Every entry
+// is written as one line and Record only returns once the whole line is on disk, so a trailing line
+// without a newline was never recorded: it is cut off the file instead of failing to open it.
+// Damage anywhere else is still an error.
+func OpenAuditLog(path string, logger *slog.Logger) (*AuditLog, error) {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	l := &AuditLog{path: path, logger: logger}
+
+	if path == "" {
+		return l, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return l, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				if err := os.Truncate(path, offset); err != nil {
+					return nil, fmt.Errorf("removing partial entry from audit log %s: %w", path, err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(len(line))
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		entry := &models.AuditEntry{}
+		if err := json.Unmarshal(line, entry); err != nil {
+			return nil, fmt.Errorf("reading audit log %s: %w", path, err)
+		}
+		l.entries = append(l.entries, entry)
+	}
+
+	l.written = len(l.entries)
+
+	return l, nil
+}
+
+// Record assigns the entry an ID and adds it to the log, then writes it to the file along with any
+// earlier entries that could not be written. The timestamp is set to the current time if it is
+// zero. If the file cannot be written the entry is kept all the same and the error is returned.
+func (l *AuditLog) Record(entry *models.AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stored := *entry
+	stored.ID = len(l.entries) + 1
+	if stored.Time.IsZero() {
+		stored.Time = time.Now().UTC()
+	}
+
+	l.entries = append(l.entries, &stored)
+	entry.ID, entry.Time = stored.ID, stored.Time
+
+	if l.path == "" {
+		l.written = len(l.entries)
+		return nil
+	}
+
+	if err := l.append(l.entries[l.written:]); err != nil {
+		return err
+	}
+	l.written = len(l.entries)
+
+	return nil
+}
+
+// append writes entries to the end of the log file and syncs it to disk. If that fails, whatever
+// part of them was written is cut off again so that the file never holds a partial line followed
+// by complete ones. Callers must hold the write lock.
+func (l *AuditLog) append(entries []*models.AuditEntry) error {
+	var lines []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	_, err = f.Write(lines)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Truncate(info.Size())
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// LastContactID returns the highest contact ID that appears in the log, or zero if it is empty.
+func (l *AuditLog) LastContactID() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	lastID := 0
+	for _, e := range l.entries {
+		lastID = max(lastID, e.ContactID)
+	}
+
+	return lastID
+}
+
+// History returns copies of the entries recorded for a contact in the given address book, newest
+// first.
+func (l *AuditLog) History(bookID, contactID int) []*models.AuditEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var history []*models.AuditEntry
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if e := l.entries[i]; e.BookID == bookID && e.ContactID == contactID {
+			cp := *e
+			history = append(history, &cp)
+		}
+	}
+
+	return history
+}
+
+// Changes returns copies of the entries recorded for the given address book after the entry with
+// ID after, oldest first, along with the ID of the newest entry in the log. The newest ID can be
+// passed back later to get only the changes made since.
+func (l *AuditLog) Changes(bookID, after int) ([]*models.AuditEntry, int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var changes []*models.AuditEntry
+	for _, e := range l.entries[min(max(after, 0), len(l.entries)):] {
+		if e.BookID == bookID {
+			cp := *e
+			changes = append(changes, &cp)
+		}
+	}
+
+	return changes, len(l.entries)
+}
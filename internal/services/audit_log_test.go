@@ -0,0 +1,78 @@
+package services
+
+import (
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestAuditLog opens the audit log at path, failing the test on error.
+func openTestAuditLog(t *testing.T, path string) *AuditLog {
+	t.Helper()
+
+	l, err := OpenAuditLog(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+func TestAuditLogDropsPartialTrailingEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	l := openTestAuditLog(t, path)
+	for id := 1; id <= 2; id++ {
+		if err := l.Record(&models.AuditEntry{Action: models.AuditCreate, ContactID: id, BookID: models.DefaultBookID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	complete, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash while appending the third entry
+	if err := os.WriteFile(path, append(complete, `{"id":3,"time":"2026-01-`...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openTestAuditLog(t, path)
+	if got := l.LastContactID(); got != 2 {
+		t.Errorf("LastContactID: got %d; want 2", got)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(complete) {
+		t.Errorf("partial entry was not removed from the file:\n%s", got)
+	}
+
+	entry := &models.AuditEntry{Action: models.AuditCreate, ContactID: 3, BookID: models.DefaultBookID}
+	if err := l.Record(entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.ID != 3 {
+		t.Errorf("Record: got ID %d; want 3", entry.ID)
+	}
+
+	if got := openTestAuditLog(t, path).LastContactID(); got != 3 {
+		t.Errorf("after reopening: got LastContactID %d; want 3", got)
+	}
+}
+
+func TestAuditLogRejectsDamagedEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	if err := os.WriteFile(path, []byte("{\"id\":1,\"time\":\"2026-01-\n{\"id\":2}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenAuditLog(path, nil); err == nil {
+		t.Error("got nil error for a damaged entry before the end of the file")
+	}
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"errors"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"time"
+)
+
+// auditFields lists the contact fields compared when recording a change, in display order.
+var auditFields = []struct {
+	name string
+	get  func(*models.Contact) string
+}{
+	{"first", func(c *models.Contact) string { return c.First }},
+	{"last", func(c *models.Contact) string { return c.Last }},
+	{"phone", func(c *models.Contact) string { return c.Phone }},
+	{"email", func(c *models.Contact) string { return c.Email }},
+}
+
+// diffContacts returns the fields that differ between two versions of a contact. A nil before
+// describes a new contact and a nil after a deleted one.
+func diffContacts(before, after *models.Contact) []models.FieldChange {
+	var changes []models.FieldChange
+
+	for _, f := range auditFields {
+		change := models.FieldChange{Field: f.name}
+		if before != nil {
+			change.Before = f.get(before)
+		}
+		if after != nil {
+			change.After = f.get(after)
+		}
+
+		if change.Before != change.After {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes
+}
+
+// auditedStore is a ContactStore that records every successful change in an AuditLog on behalf of
+// one actor and request. Reads go straight to the wrapped store.
+//
+// Entries are recorded after the change has been saved. A change that was saved is never reported
+// as failed: if its entry cannot be written to the file, the failure is logged and the entry is
+// written with the next one (see AuditLog), so that clients do not retry a change that happened.
+type auditedStore struct {
+	ContactStore
+	log       *AuditLog
+	actor     string
+	requestID string
+}
+
+// Wrap returns a ContactStore that records every change made through store in the log, attributed
+// to actor and tagged with requestID.
+func (l *AuditLog) Wrap(store ContactStore, actor, requestID string) ContactStore {
+	return &auditedStore{ContactStore: store, log: l, actor: actor, requestID: requestID}
+}
+
+// record writes an audit entry for a change to contact.
+func (s *auditedStore) record(action string, contact, before, after *models.Contact) {
+	s.log.recordSaved(&models.AuditEntry{
+		Action:     action,
+		ContactID:  contact.ID,
+		ContactUID: contact.UID,
+		BookID:     contact.BookID,
+		Actor:      s.actor,
+		RequestID:  s.requestID,
+		Changes:    diffContacts(before, after),
+	})
+}
+
+// recordSaved records the entry for a change that has already been saved, logging rather than
+// returning a failure to write it.
+func (l *AuditLog) recordSaved(entry *models.AuditEntry) {
+	if err := l.Record(entry); err != nil {
+		l.logger.Error("audit entry could not be written, will retry with the next one",
+			"error", err.Error(), "action", entry.Action, "contact", entry.ContactID, "request_id", entry.RequestID)
+	}
+}
+
+// InBook returns an audited view of another address book for the same actor and request.
+func (s *auditedStore) InBook(bookID int) ContactStore {
+	return s.log.Wrap(s.ContactStore.InBook(bookID), s.actor, s.requestID)
+}
+
+// Insert stores the contact and records its initial field values.
+func (s *auditedStore) Insert(contact *models.Contact) error {
+	if err := s.ContactStore.Insert(contact); err != nil {
+		return err
+	}
+
+	s.record(models.AuditCreate, contact, nil, contact)
+
+	return nil
+}
+
+// Update replaces the stored contact and records the fields that changed.
+func (s *auditedStore) Update(contact *models.Contact) error {
+	before, err := s.ContactStore.Get(contact.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.ContactStore.Update(contact); err != nil {
+		return err
+	}
+
+	s.record(models.AuditUpdate, contact, before, contact)
+
+	return nil
+}
+
+// SaveMany saves the contacts and records an entry for each one created or updated.
+func (s *auditedStore) SaveMany(contacts []*models.Contact) error {
+	before := make(map[int]*models.Contact)
+	for _, c := range contacts {
+		if c.ID == 0 {
+			continue
+		}
+
+		existing, err := s.ContactStore.Get(c.ID)
+		if err != nil {
+			return err
+		}
+		before[c.ID] = existing
+	}
+
+	if err := s.ContactStore.SaveMany(contacts); err != nil {
+		return err
+	}
+
+	for _, c := range contacts {
+		action := models.AuditCreate
+		if before[c.ID] != nil {
+			action = models.AuditUpdate
+		}
+
+		s.record(action, c, before[c.ID], c)
+	}
+
+	return nil
+}
+
+// Delete moves the contact to the trash and records the field values it had.
+func (s *auditedStore) Delete(id int) error {
+	before, err := s.ContactStore.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.ContactStore.Delete(id); err != nil {
+		return err
+	}
+
+	s.record(models.AuditDelete, before, before, nil)
+
+	return nil
+}
+
+// DeleteMany moves the listed contacts to the trash and records an entry for each one that existed.
+func (s *auditedStore) DeleteMany(ids []int) (int, error) {
+	var existing []*models.Contact
+	for _, id := range ids {
+		c, err := s.ContactStore.Get(id)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				continue
+			}
+			return 0, err
+		}
+		existing = append(existing, c)
+	}
+
+	removed, err := s.ContactStore.DeleteMany(ids)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, c := range existing {
+		s.record(models.AuditDelete, c, c, nil)
+	}
+
+	return removed, nil
+}
+
+// trashed returns the book's contact in the trash with the given ID, or models.ErrNoRecord.
+func (s *auditedStore) trashed(id int) (*models.Contact, error) {
+	trash, err := s.ContactStore.Trash()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range trash {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+// Restore takes the contact out of the trash and records the field values it came back with.
+func (s *auditedStore) Restore(id int) error {
+	contact, err := s.trashed(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.ContactStore.Restore(id); err != nil {
+		return err
+	}
+
+	s.record(models.AuditRestore, contact, nil, contact)
+
+	return nil
+}
+
+// Purge permanently removes the contact from the trash and records the field values it had.
+func (s *auditedStore) Purge(id int) error {
+	contact, err := s.trashed(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.ContactStore.Purge(id); err != nil {
+		return err
+	}
+
+	s.record(models.AuditPurge, contact, contact, nil)
+
+	return nil
+}
+
+// auditedPurger is a TrashPurger that records every contact it purges in an AuditLog on behalf of
+// one actor.
+type auditedPurger struct {
+	TrashPurger
+	log   *AuditLog
+	actor string
+}
+
+// WrapPurger returns a TrashPurger that records every contact purged through purger in the log,
+// attributed to actor. Purges are not made on behalf of a request, so entries have no request ID.
+func (l *AuditLog) WrapPurger(purger TrashPurger, actor string) TrashPurger {
+	return &auditedPurger{TrashPurger: purger, log: l, actor: actor}
+}
+
+// PurgeExpired purges the expired contacts and records the field values each one had. As with
+// auditedStore, entries that cannot be written are logged rather than failing the purge.
+func (p *auditedPurger) PurgeExpired(before time.Time) ([]*models.Contact, error) {
+	purged, err := p.TrashPurger.PurgeExpired(before)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range purged {
+		p.log.recordSaved(&models.AuditEntry{
+			Action:     models.AuditPurge,
+			ContactID:  c.ID,
+			ContactUID: c.UID,
+			BookID:     c.BookID,
+			Actor:      p.actor,
+			Changes:    diffContacts(c, nil),
+		})
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"bytes"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditedStoreKeepsChangeWhenEntryCannotBeWritten(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "audit")
+	path := filepath.Join(blocker, "audit.jsonl")
+
+	var logged bytes.Buffer
+	log, err := OpenAuditLog(path, slog.New(slog.NewTextHandler(&logged, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a file where the log's directory should be, so every write fails
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewMemoryStore(nil)
+	first := &models.Contact{First: "First", Last: "Last", Phone: "555-0100", Email: "first@example.com"}
+	if err := log.Wrap(store, "alice", "req-1").Insert(first); err != nil {
+		t.Fatalf("Insert: got %v; want nil for a saved contact", err)
+	}
+
+	if _, err := store.Get(first.ID); err != nil {
+		t.Errorf("contact was not saved: %v", err)
+	}
+	if !strings.Contains(logged.String(), "req-1") {
+		t.Errorf("the failure was not logged: %q", logged.String())
+	}
+	if got := len(log.History(models.DefaultBookID, first.ID)); got != 1 {
+		t.Errorf("got %d history entries; want 1", got)
+	}
+
+	// once the file can be written, the next entry brings the missed one with it
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+
+	second := &models.Contact{First: "Second", Last: "Last", Phone: "555-0101", Email: "second@example.com"}
+	if err := log.Wrap(store, "alice", "req-2").Insert(second); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestAuditLog(t, path)
+	for _, id := range []int{first.ID, second.ID} {
+		if got := len(reopened.History(models.DefaultBookID, id)); got != 1 {
+			t.Errorf("contact %d: got %d entries in the file; want 1", id, got)
+		}
+	}
+}
+
+func TestAuditedPurgerRecordsExpiredContacts(t *testing.T) {
+	stores := map[string]ContactStore{
+		"memory": NewMemoryStore(nil),
+		"sqlite": openTestSQLiteStore(t),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			c := &models.Contact{First: "First", Last: "Last", Phone: "555-0100", Email: "expired@example.com"}
+			if err := store.Insert(c); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete(c.ID); err != nil {
+				t.Fatal(err)
+			}
+
+			log := openTestAuditLog(t, "")
+			purged, err := log.WrapPurger(store.(TrashPurger), "system").PurgeExpired(time.Now().Add(time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(purged) != 1 || purged[0].ID != c.ID {
+				t.Fatalf("got %d purged contacts; want contact %d", len(purged), c.ID)
+			}
+
+			history := log.History(models.DefaultBookID, c.ID)
+			if len(history) != 1 {
+				t.Fatalf("got %d history entries; want 1", len(history))
+			}
+			if e := history[0]; e.Action != models.AuditPurge || e.Actor != "system" || len(e.Changes) != 4 {
+				t.Errorf("got %s by %q with %d changes; want purge by \"system\" with 4 changes", e.Action, e.Actor, len(e.Changes))
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultBookName is the name given to the shared address book when the books file does not list it.
+const defaultBookName = "Shared contacts"
+
+// BookStore manages address books in a JSON file kept alongside the contact data. The contacts
+// themselves live in the ContactStore; this store only records each book's name, owner and members.
+// The shared default book always exists. It is safe for concurrent use.
+type BookStore struct {
+	mu    sync.RWMutex
+	path  string
+	books []*models.AddressBook
+}
+
+// OpenBookStore loads the address books stored at path. A missing file yields a store holding only
+// the default book that is created on the first write, and an empty path keeps the books in memory
+// only.
+func OpenBookStore(path string) (*BookStore, error) {
+	s := &BookStore{path: path}
+
+	if path != "" {
+		if err := loadJSONFile(path, &s.books); err != nil {
+			return nil, err
+		}
+	}
+
+	if !slices.ContainsFunc(s.books, func(b *models.AddressBook) bool { return b.ID == models.DefaultBookID }) {
+		s.books = slices.Insert(s.books, 0, &models.AddressBook{ID: models.DefaultBookID, Name: defaultBookName, CreatedAt: time.Now().UTC()})
+	}
+
+	return s, nil
+}
+
+// cloneBook returns a copy of the book that shares no memory with the original.
+func cloneBook(b *models.AddressBook) *models.AddressBook {
+	cp := *b
+	cp.MemberIDs = slices.Clone(b.MemberIDs)
+	return &cp
+}
+
+// save writes the proposed books to disk and, if that succeeds, makes them current. Callers must
+// hold the write lock.
+func (s *BookStore) save(books []*models.AddressBook) error {
+	if s.path != "" {
+		if err := saveJSONFile(s.path, books, 0o600); err != nil {
+			return err
+		}
+	}
+
+	s.books = books
+
+	return nil
+}
+
+// Create adds a new address book owned by the given user and returns it.
+func (s *BookStore) Create(name string, ownerID int) (*models.AddressBook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := 1
+	for _, b := range s.books {
+		id = max(id, b.ID+1)
+	}
+
+	book := &models.AddressBook{
+		ID:        id,
+		Name:      name,
+		OwnerID:   ownerID,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if err := s.save(append(slices.Clip(s.books), book)); err != nil {
+		return nil, err
+	}
+
+	return cloneBook(book), nil
+}
+
+// Get returns a copy of the address book with the given ID, or models.ErrNoRecord if not found.
+func (s *BookStore) Get(id int) (*models.AddressBook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, b := range s.books {
+		if b.ID == id {
+			return cloneBook(b), nil
+		}
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+// ForUser returns copies of the address books the user with the given ID may access, ordered by
+// ID. Anonymous visitors (ID 0) only get the shared books.
+func (s *BookStore) ForUser(userID int) []*models.AddressBook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var books []*models.AddressBook
+	for _, b := range s.books {
+		if b.CanAccess(userID) {
+			books = append(books, cloneBook(b))
+		}
+	}
+
+	return books
+}
+
+// AddMember shares the address book with another user. Adding the owner or an existing member is a
+// no-op. Returns models.ErrNoRecord if the book does not exist.
+func (s *BookStore) AddMember(bookID, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.books, func(b *models.AddressBook) bool { return b.ID == bookID })
+	if i < 0 {
+		return models.ErrNoRecord
+	}
+
+	if s.books[i].OwnerID == userID || slices.Contains(s.books[i].MemberIDs, userID) {
+		return nil
+	}
+
+	book := cloneBook(s.books[i])
+	book.MemberIDs = append(book.MemberIDs, userID)
+
+	books := slices.Clone(s.books)
+	books[i] = book
+
+	return s.save(books)
+}
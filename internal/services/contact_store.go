@@ -0,0 +1,183 @@
+package services
+
+import (
+	"cmp"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ContactStore describes the operations the web handlers need from a contact backend.
+// Implementations must return models.ErrNoRecord when a requested contact does not exist.
+//
+// A ContactStore works on the contacts of a single address book: contacts in other books are
+// invisible to every method, and email addresses only need to be unique within a book. Backends
+// start out on models.DefaultBookID; InBook switches to another book.
+//
+// Deleting a contact moves it to the book's trash. Contacts in the trash are invisible to every
+// method except Trash, Restore and Purge, and do not count towards email uniqueness.
+type ContactStore interface {
+	// InBook returns a store that works on the contacts of the given address book and shares the
+	// underlying storage with this one.
+	InBook(bookID int) ContactStore
+
+	// Get returns the contact with the given ID.
+	Get(id int) (*models.Contact, error)
+
+	// GetByUID returns the contact with the given UID. Contacts without a UID are never returned.
+	GetByUID(uid string) (*models.Contact, error)
+
+	// GetAll returns every contact, optionally filtered by a case-insensitive search query.
+	GetAll(query ...string) ([]*models.Contact, error)
+
+	// List returns one page of contacts matching the params along with the total number of matches.
+	List(params ListParams) ([]*models.Contact, int, error)
+
+	// Insert assigns the next available ID to the contact and stores it in the book.
+	Insert(contact *models.Contact) error
+
+	// Update replaces the stored contact that has the same ID. The stored UID is kept.
+	Update(contact *models.Contact) error
+
+	// SaveMany inserts the contacts whose ID is zero and updates the others, like Update, in a
+	// single write. Either every contact is saved or, if any of them fails, none are.
+	SaveMany(contacts []*models.Contact) error
+
+	// Delete moves the contact with the given ID to the trash.
+	Delete(id int) error
+
+	// DeleteMany moves every contact whose ID is listed to the trash in a single write and returns
+	// how many were moved. IDs that do not exist are ignored.
+	DeleteMany(ids []int) (int, error)
+
+	// EmailUnique reports whether no contact other than the one with the given ID uses the email address.
+	EmailUnique(email string, id int) bool
+
+	// Trash returns the contacts in the trash, most recently deleted first.
+	Trash() ([]*models.Contact, error)
+
+	// Restore takes the contact with the given ID out of the trash. It returns
+	// models.ErrDuplicateEmail if another contact has started using its email address meanwhile.
+	Restore(id int) error
+
+	// Purge permanently removes the contact with the given ID from the trash.
+	Purge(id int) error
+}
+
+// TrashPurger is implemented by backends that can empty the trash of every address book at once.
+// The server uses it to purge contacts automatically once the retention period has passed.
+type TrashPurger interface {
+	// PurgeExpired permanently removes every contact that was moved to the trash before the cutoff
+	// and returns the removed contacts.
+	PurgeExpired(before time.Time) ([]*models.Contact, error)
+}
+
+// IDReserver is implemented by backends that can be told about IDs used before they were opened.
+// Audit entries are keyed by contact ID, so a new contact must never be given the ID of one that
+// was purged; otherwise it would inherit the old contact's history.
+type IDReserver interface {
+	// ReserveIDs makes sure that every contact inserted from now on gets an ID above lastID.
+	ReserveIDs(lastID int) error
+}
+
+// ListParams selects a page of contacts.
+type ListParams struct {
+	// Query filters contacts with the same case-insensitive matching as GetAll.
+	Query string
+
+	// Page is the 1-based page number. Values below 1 are treated as the first page.
+	Page int
+
+	// Size is the number of contacts per page. Zero or less returns every match.
+	Size int
+
+	// Sort names the field to order by: "first", "last", "phone" or "email", prefixed with "-"
+	// for descending order. Empty or unknown values order by ID. Ties are always broken by ID
+	// so that paging through a sorted list is stable.
+	Sort string
+}
+
+// sortFields maps the sort keys accepted in ListParams.Sort to contact field accessors.
+var sortFields = map[string]func(*models.Contact) string{
+	"first": func(c *models.Contact) string { return c.First },
+	"last":  func(c *models.Contact) string { return c.Last },
+	"phone": func(c *models.Contact) string { return c.Phone },
+	"email": func(c *models.Contact) string { return c.Email },
+}
+
+// ValidSort reports whether sort is a value ListParams.Sort understands.
+func ValidSort(sort string) bool {
+	_, ok := sortFields[strings.TrimPrefix(sort, "-")]
+	return ok
+}
+
+// sortKey splits ListParams.Sort into a known field name and direction. The field is empty when
+// the list should be ordered by ID.
+func (p ListParams) sortKey() (field string, desc bool) {
+	field = strings.TrimPrefix(p.Sort, "-")
+	if _, ok := sortFields[field]; !ok {
+		return "", false
+	}
+
+	return field, strings.HasPrefix(p.Sort, "-")
+}
+
+// sort orders contacts in place according to p.Sort, comparing case-insensitively and breaking
+// ties by ID.
+func (p ListParams) sort(contacts []*models.Contact) {
+	field, desc := p.sortKey()
+	if field == "" {
+		slices.SortFunc(contacts, func(a, b *models.Contact) int { return cmp.Compare(a.ID, b.ID) })
+		return
+	}
+
+	value := sortFields[field]
+
+	slices.SortFunc(contacts, func(a, b *models.Contact) int {
+		c := strings.Compare(strings.ToLower(value(a)), strings.ToLower(value(b)))
+		if desc {
+			c = -c
+		}
+		if c == 0 {
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		return c
+	})
+}
+
+// offset returns the index of the first contact on the requested page.
+func (p ListParams) offset() int {
+	if p.Size <= 0 || p.Page <= 1 {
+		return 0
+	}
+
+	return (p.Page - 1) * p.Size
+}
+
+// paginate returns the slice of contacts that falls on the requested page.
+func (p ListParams) paginate(contacts []*models.Contact) []*models.Contact {
+	if p.Size <= 0 {
+		return contacts
+	}
+
+	start := min(p.offset(), len(contacts))
+	end := min(start+p.Size, len(contacts))
+
+	return contacts[start:end]
+}
+
+// Compile-time checks that the bundled backends satisfy ContactStore, TrashPurger and IDReserver.
+var (
+	_ ContactStore = (*MemoryStore)(nil)
+	_ ContactStore = (*ContactRepository)(nil)
+	_ ContactStore = (*SQLiteStore)(nil)
+
+	_ TrashPurger = (*MemoryStore)(nil)
+	_ TrashPurger = (*ContactRepository)(nil)
+	_ TrashPurger = (*SQLiteStore)(nil)
+
+	_ IDReserver = (*MemoryStore)(nil)
+	_ IDReserver = (*ContactRepository)(nil)
+	_ IDReserver = (*SQLiteStore)(nil)
+)
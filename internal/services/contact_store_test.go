@@ -0,0 +1,186 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// concurrentWorkers is the number of goroutines testConcurrentUse runs at once.
+	concurrentWorkers = 8
+
+	// concurrentRounds is the number of times each goroutine goes through every method.
+	concurrentRounds = 5
+)
+
+// testConcurrentUse calls every ContactStore method from many goroutines at once. Each goroutine
+// works on its own contacts, half of them in a second address book, so every call must succeed;
+// run it with -race to catch unsynchronized access. Each round leaves exactly one contact behind,
+// which the final counts check.
+func testConcurrentUse(t *testing.T, store ContactStore) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrentWorkers)
+
+	for w := range concurrentWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			book := store
+			if w%2 == 1 {
+				book = store.InBook(2)
+			}
+
+			if err := exerciseStore(book, w); err != nil {
+				errs <- fmt.Errorf("worker %d: %w", w, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	for _, bookID := range []int{models.DefaultBookID, 2} {
+		contacts, err := store.InBook(bookID).GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := concurrentWorkers / 2 * concurrentRounds
+		if len(contacts) != want {
+			t.Errorf("book %d: got %d contacts; want %d", bookID, len(contacts), want)
+		}
+	}
+}
+
+// exerciseStore runs every ContactStore method concurrentRounds times on contacts only worker w
+// uses.
+func exerciseStore(store ContactStore, w int) error {
+	for i := range concurrentRounds {
+		c := &models.Contact{
+			UID:   fmt.Sprintf("w%d-r%d", w, i),
+			First: "First",
+			Last:  "Last",
+			Phone: "555-0100",
+			Email: fmt.Sprintf("w%d-r%d@example.com", w, i),
+		}
+
+		if err := store.Insert(c); err != nil {
+			return fmt.Errorf("Insert: %w", err)
+		}
+
+		got, err := store.Get(c.ID)
+		if err != nil {
+			return fmt.Errorf("Get: %w", err)
+		}
+		if got.Email != c.Email {
+			return fmt.Errorf("Get: got email %q; want %q", got.Email, c.Email)
+		}
+
+		if got, err := store.GetByUID(c.UID); err != nil || got.ID != c.ID {
+			return fmt.Errorf("GetByUID: got %v, %v; want contact %d", got, err, c.ID)
+		}
+
+		c.Phone = "555-0199"
+		if err := store.Update(c); err != nil {
+			return fmt.Errorf("Update: %w", err)
+		}
+
+		if _, err := store.GetAll(); err != nil {
+			return fmt.Errorf("GetAll: %w", err)
+		}
+		if _, err := store.GetAll(fmt.Sprintf("w%d-", w)); err != nil {
+			return fmt.Errorf("GetAll with query: %w", err)
+		}
+		if _, _, err := store.List(ListParams{Query: "example", Page: 2, Size: 3, Sort: "-email"}); err != nil {
+			return fmt.Errorf("List: %w", err)
+		}
+
+		if store.EmailUnique(c.Email, 0) {
+			return errors.New("EmailUnique: stored address reported as unused")
+		}
+		if !store.EmailUnique(c.Email, c.ID) {
+			return errors.New("EmailUnique: address reported as used by its own contact")
+		}
+
+		kept := &models.Contact{First: "Kept", Last: "Last", Phone: "555-0100", Email: fmt.Sprintf("w%d-r%d-kept@example.com", w, i)}
+		c.Last = "Changed"
+		if err := store.SaveMany([]*models.Contact{kept, c}); err != nil {
+			return fmt.Errorf("SaveMany: %w", err)
+		}
+
+		if err := store.Delete(c.ID); err != nil {
+			return fmt.Errorf("Delete: %w", err)
+		}
+		if _, err := store.Trash(); err != nil {
+			return fmt.Errorf("Trash: %w", err)
+		}
+		if err := store.Restore(c.ID); err != nil {
+			return fmt.Errorf("Restore: %w", err)
+		}
+
+		if n, err := store.DeleteMany([]int{c.ID}); err != nil || n != 1 {
+			return fmt.Errorf("DeleteMany: got %d, %v; want 1, nil", n, err)
+		}
+		if err := store.Purge(c.ID); err != nil {
+			return fmt.Errorf("Purge: %w", err)
+		}
+
+		if purger, ok := store.(TrashPurger); ok {
+			// nothing is old enough, so this only has to run alongside the other calls safely
+			if _, err := purger.PurgeExpired(time.Now().Add(-time.Hour)); err != nil {
+				return fmt.Errorf("PurgeExpired: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// testIDsNotReused checks that the ID of a purged contact is never given to a new one, and that
+// ReserveIDs moves the next ID past the reserved ones.
+func testIDsNotReused(t *testing.T, store ContactStore) {
+	t.Helper()
+
+	insert := func(email string) *models.Contact {
+		t.Helper()
+
+		c := &models.Contact{First: "First", Last: "Last", Phone: "555-0100", Email: email}
+		if err := store.Insert(c); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	insert("kept@example.com")
+	purged := insert("purged@example.com")
+
+	if err := store.Delete(purged.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Purge(purged.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := insert("after-purge@example.com"); c.ID != purged.ID+1 {
+		t.Errorf("after purge: got ID %d; want %d", c.ID, purged.ID+1)
+	}
+
+	if err := store.(IDReserver).ReserveIDs(100); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := insert("after-reserve@example.com"); c.ID != 101 {
+		t.Errorf("after ReserveIDs: got ID %d; want 101", c.ID)
+	}
+}
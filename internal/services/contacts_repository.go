@@ -3,133 +3,226 @@ package services
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/code-chimp/htmx-go-example/internal/models"
+	"io/fs"
 	"os"
-	"strings"
+	"path/filepath"
+	"time"
 )
 
-// ContactRepository manages a collection of contacts.
+const (
+	// DefaultContactsFile is the JSON file used when no path is configured.
+	DefaultContactsFile = "./data/contacts.json"
+
+	// defaultBackupCount is the number of previous versions of the contacts file kept by default.
+	defaultBackupCount = 5
+)
+
+// RepositoryOptions configures a ContactRepository. The zero value reads DefaultContactsFile.
+type RepositoryOptions struct {
+	// Path is the JSON file holding the contacts. Defaults to DefaultContactsFile.
+	Path string
+
+	// BackupDir is where previous versions of the file are kept. Defaults to a "backups"
+	// directory next to Path.
+	BackupDir string
+
+	// BackupCount is the number of previous versions to keep. Zero keeps the default of five
+	// and a negative value disables backups.
+	BackupCount int
+
+	// CreateIfMissing creates the file, containing Seed, when neither it nor a backup exists.
+	CreateIfMissing bool
+
+	// Seed is the initial set of contacts written when the file is created.
+	Seed []*models.Contact
+
+	// Pretty indents the JSON written to the file so it is easy to read and diff.
+	Pretty bool
+}
+
+// ContactRepository is a ContactStore backed by a JSON file. Reads are served from the embedded
+// MemoryStore and every change is written back to the file.
+//
+// Writes are atomic: the new contents go to a temporary file which is synced and then renamed over
+// the old one. Before each write the previous version is copied into the backup directory, keeping
+// only the newest copies.
 type ContactRepository struct {
-	contacts []*models.Contact
+	*MemoryStore
+	path          string
+	backupDir     string
+	backups       int
+	pretty        bool
+	recoveredFrom string
 }
 
-// NewRepository creates a new ContactRepository from the data in the contacts.json file.
-// It reads the JSON file, unmarshal the data into a slice of Contact structs, and returns a new ContactRepository.
-// Returns an error if the file cannot be read or the JSON cannot be unmarshalled.
+// NewRepository creates a new ContactRepository from the data in the default contacts.json file.
 func NewRepository() (*ContactRepository, error) {
-	file, err := os.Open("./data/contacts.json")
-	if err != nil {
-		return nil, err
+	return OpenRepository(RepositoryOptions{})
+}
+
+// OpenRepository creates a new ContactRepository configured by opts.
+// If the file is missing or cannot be parsed, the newest backup that can be parsed is used instead
+// and written back as the current file; the damaged file is kept alongside with a .corrupt suffix.
+// When there is nothing to recover and opts.CreateIfMissing is set, the file is created from opts.Seed.
+// Returns an error if neither the file nor any backup can be read.
+func OpenRepository(opts RepositoryOptions) (*ContactRepository, error) {
+	repo := &ContactRepository{
+		path:      opts.Path,
+		backupDir: opts.BackupDir,
+		backups:   opts.BackupCount,
+		pretty:    opts.Pretty,
 	}
-	defer file.Close()
 
-	var contacts []*models.Contact
-	if err := json.NewDecoder(file).Decode(&contacts); err != nil {
-		return nil, err
+	if repo.path == "" {
+		repo.path = DefaultContactsFile
 	}
 
-	return &ContactRepository{contacts: contacts}, nil
-}
+	if repo.backupDir == "" {
+		repo.backupDir = filepath.Join(filepath.Dir(repo.path), "backups")
+	}
+
+	if repo.backups == 0 {
+		repo.backups = defaultBackupCount
+	}
 
-// saveToFile writes the current state of the contacts slice to the contacts.json file.
-// Returns an error if the file cannot be created or the JSON cannot be marshaled.
-func (r *ContactRepository) saveToFile() error {
-	file, err := os.Create("./data/contacts.json")
+	contacts, err := readContactsFile(repo.path)
 	if err != nil {
-		return err
+		contacts, err = repo.recover(err)
 	}
-	defer file.Close()
 
-	return json.NewEncoder(file).Encode(r.contacts)
-}
+	if err != nil && errors.Is(err, fs.ErrNotExist) && opts.CreateIfMissing {
+		contacts, err = repo.create(opts.Seed)
+	}
 
-// getNextID returns the next available ID for a new contact.
-func (r *ContactRepository) getNextID() int {
-	maxID := 0
-	for _, contact := range r.contacts {
-		if contact.ID > maxID {
-			maxID = contact.ID
-		}
+	if err != nil {
+		return nil, err
 	}
-	return maxID + 1
+
+	repo.MemoryStore = NewMemoryStore(contacts)
+	repo.persist = repo.saveToFile
+
+	return repo, nil
 }
 
-// Get returns a contact by ID if found, or an error if not found.
-func (r *ContactRepository) Get(id int) (*models.Contact, error) {
-	for _, c := range r.contacts {
-		if c.ID == id {
-			return c, nil
-		}
+// create writes a new contacts file, and its directory, containing seed.
+func (r *ContactRepository) create(seed []*models.Contact) ([]*models.Contact, error) {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return nil, err
 	}
-	return nil, errors.New("contact not found")
-}
 
-// GetAll returns all contacts in the repository. If a query string is provided, it filters the contacts
-// whose Email, First, or Last includes the query string (case insensitive).
-func (r *ContactRepository) GetAll(query ...string) ([]*models.Contact, error) {
-	if len(query) == 0 || query[0] == "" {
-		return r.contacts, nil
+	if seed == nil {
+		seed = []*models.Contact{}
 	}
 
-	q := strings.ToLower(query[0])
-	var filteredContacts []*models.Contact
+	data, err := r.encode(seed)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, c := range r.contacts {
-		if strings.Contains(strings.ToLower(c.Email), q) ||
-			strings.Contains(strings.ToLower(c.First), q) ||
-			strings.Contains(strings.ToLower(c.Last), q) ||
-			strings.Contains(strings.ToLower(c.Phone), q) {
-			filteredContacts = append(filteredContacts, c)
-		}
+	if err := writeFileAtomic(r.path, data, 0o644); err != nil {
+		return nil, err
 	}
 
-	return filteredContacts, nil
+	return seed, nil
 }
 
-// Insert adds a new contact to the repository and persists the change to the contacts.json file.
-// Returns the inserted contact and an error if the file cannot be saved.
-func (r *ContactRepository) Insert(contact *models.Contact) error {
-	contact.ID = r.getNextID()
-	r.contacts = append(r.contacts, contact)
+// Path returns the JSON file backing the repository.
+func (r *ContactRepository) Path() string {
+	return r.path
+}
+
+// RecoveredFrom returns the backup file the repository was restored from at startup, or an empty
+// string if the contacts file was read normally.
+func (r *ContactRepository) RecoveredFrom() string {
+	return r.recoveredFrom
+}
 
-	err := r.saveToFile()
+// readContactsFile reads and decodes a JSON contacts file.
+func readContactsFile(path string) ([]*models.Contact, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer file.Close()
+
+	var contacts []*models.Contact
+	if err := json.NewDecoder(file).Decode(&contacts); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
 
-	return nil
+	return contacts, nil
 }
 
-// Update modifies an existing contact in the repository and persists the change to the contacts.json file.
-// Returns an error if the contact is not found or the file cannot be saved.
-func (r *ContactRepository) Update(contact *models.Contact) error {
-	for i, c := range r.contacts {
-		if c.ID == contact.ID {
-			r.contacts[i] = contact
-			return r.saveToFile()
+// recover restores the contacts from the newest readable backup after the contacts file failed to
+// load with cause. The unreadable file, if any, is moved aside rather than deleted.
+func (r *ContactRepository) recover(cause error) ([]*models.Contact, error) {
+	backups, err := listBackups(r.path, r.backupDir)
+	if err != nil {
+		return nil, errors.Join(cause, err)
+	}
+
+	for _, backup := range backups {
+		contacts, err := readContactsFile(backup)
+		if err != nil {
+			continue
+		}
+
+		if _, err := os.Stat(r.path); err == nil {
+			corrupt := fmt.Sprintf("%s.corrupt-%s", r.path, time.Now().UTC().Format(backupTimeFormat))
+			if err := os.Rename(r.path, corrupt); err != nil {
+				return nil, err
+			}
+		}
+
+		data, err := r.encode(contacts)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := writeFileAtomic(r.path, data, 0o644); err != nil {
+			return nil, err
 		}
+
+		r.recoveredFrom = backup
+
+		return contacts, nil
 	}
-	return errors.New("contact not found")
+
+	return nil, cause
 }
 
-// Delete removes a contact from the repository by ID and persists the change to the contacts.json file.
-// Returns an error if the contact is not found or the file cannot be saved.
-func (r *ContactRepository) Delete(id int) error {
-	for i, c := range r.contacts {
-		if c.ID == id {
-			r.contacts = append(r.contacts[:i], r.contacts[i+1:]...)
-			return r.saveToFile()
-		}
+// saveToFile backs up the current contacts file and then atomically replaces it with the given contacts.
+// Returns an error if the backup or the write fails, in which case the previous file is left intact.
+func (r *ContactRepository) saveToFile(contacts []*models.Contact) error {
+	data, err := r.encode(contacts)
+	if err != nil {
+		return err
 	}
-	return errors.New("contact not found")
+
+	if err := backupFile(r.path, r.backupDir, r.backups); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(r.path, data, 0o644)
 }
 
-// EmailUnique checks if a contact with the same email address already exists in the repository.
-func (r *ContactRepository) EmailUnique(email string, id int) bool {
-	for _, c := range r.contacts {
-		if c.Email == email && c.ID != id {
-			return false
-		}
+// encode marshals the contacts as JSON, indented when the repository is configured for pretty output.
+func (r *ContactRepository) encode(contacts []*models.Contact) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	if r.pretty {
+		data, err = json.MarshalIndent(contacts, "", "  ")
+	} else {
+		data, err = json.Marshal(contacts)
 	}
-	return true
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
 }
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestContactRepositoryConcurrentUse(t *testing.T) {
+	repo, err := OpenRepository(RepositoryOptions{
+		Path:            filepath.Join(t.TempDir(), "contacts.json"),
+		CreateIfMissing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testConcurrentUse(t, repo)
+
+	// the file must hold what the store holds after all those writes
+	reopened, err := OpenRepository(RepositoryOptions{Path: repo.Path()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := repo.GetAll()
+	got, _ := reopened.GetAll()
+	if len(got) != len(want) {
+		t.Errorf("reopened file has %d contacts; want %d", len(got), len(want))
+	}
+}
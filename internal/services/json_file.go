@@ -0,0 +1,187 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// backupTimeFormat is used to stamp backup file names. It sorts lexically in chronological order.
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// writeFileAtomic replaces the file at path with data without ever exposing a partially written
+// file. The data is written to a temporary file in the same directory, flushed to stable storage
+// and then renamed over the target, so readers see either the old or the new contents.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	// clean up the temp file if anything goes wrong before the rename
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+
+	if err = tmp.Chmod(perm); err != nil {
+		return err
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir flushes a directory entry so a completed rename survives a crash. Windows does not
+// support syncing directories, so it is skipped there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// backupName returns the file name used for a backup of base taken at t.
+func backupName(base string, t time.Time) string {
+	ext := filepath.Ext(base)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(base, ext), t.UTC().Format(backupTimeFormat), ext)
+}
+
+// backupFile copies the current contents of path into dir under a timestamped name and then
+// removes all but the newest keep backups. A missing source file is not an error.
+func backupFile(path, dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	dst, err := os.Create(filepath.Join(dir, backupName(filepath.Base(path), time.Now())))
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	backups, err := listBackups(path, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, old := range backups[min(keep, len(backups)):] {
+		if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listBackups returns the backups of path found in dir, newest first.
+func listBackups(path, dir string) ([]string, error) {
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+
+	backups, err := filepath.Glob(filepath.Join(dir, strings.TrimSuffix(base, ext)+"-*"+ext))
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(backups)
+	slices.Reverse(backups)
+
+	return backups, nil
+}
+
+// loadJSONFile decodes the JSON file at path into dst. A missing file leaves dst untouched and is
+// not an error, so stores can start empty.
+func loadJSONFile(path string, dst any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := json.Unmarshal(data, dst); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// saveJSONFile atomically writes v to path as indented JSON, creating the directory if needed.
+func saveJSONFile(path string, v any, perm os.FileMode) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, append(data, '\n'), perm)
+}
+
+// fileChanged reports whether the file described by info is not the one described by loaded, the
+// file as it was when last read or written. Either is nil for a file that does not exist. Files are
+// replaced by rename on every write, so a write by another process always yields a different file.
+func fileChanged(info, loaded os.FileInfo) bool {
+	if info == nil || loaded == nil {
+		return info != loaded
+	}
+
+	return !os.SameFile(info, loaded) || !info.ModTime().Equal(loaded.ModTime()) || info.Size() != loaded.Size()
+}
@@ -0,0 +1,478 @@
+package services
+
+import (
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a ContactStore that keeps its contacts in memory. On its own nothing is written
+// to disk, which makes it handy for tests and throwaway demos; the JSON file store builds on it by
+// supplying a persist hook.
+//
+// MemoryStore is safe for concurrent use. Readers share a read lock while writers hold the write
+// lock for the whole change, including the persist call, so writes are serialized and never
+// interleave on disk. Contacts are copied on the way in and out so callers can never modify the
+// stored data without going through Update.
+//
+// Every address book lives in the same slice. A MemoryStore works on the contacts of one book;
+// InBook returns a view of another book that shares the data, lock and persist hook.
+type MemoryStore struct {
+	*memoryData
+	book int
+}
+
+// memoryData is the state shared by every book view of a MemoryStore.
+type memoryData struct {
+	mu       sync.RWMutex
+	contacts []*models.Contact
+	persist  func([]*models.Contact) error
+
+	// lastID is the highest ID ever assigned, including to contacts that have since been purged.
+	lastID int
+}
+
+// NewMemoryStore creates a MemoryStore seeded with the given contacts, working on the default
+// address book. Contacts without a book are moved into the default book.
+func NewMemoryStore(contacts []*models.Contact) *MemoryStore {
+	contacts = cloneContacts(contacts)
+	lastID := 0
+	for _, c := range contacts {
+		if c.BookID == 0 {
+			c.BookID = models.DefaultBookID
+		}
+		lastID = max(lastID, c.ID)
+	}
+
+	return &MemoryStore{memoryData: &memoryData{contacts: contacts, lastID: lastID}, book: models.DefaultBookID}
+}
+
+// InBook returns a view of the store that works on the contacts of the given address book.
+func (s *MemoryStore) InBook(bookID int) ContactStore {
+	return &MemoryStore{memoryData: s.memoryData, book: bookID}
+}
+
+// cloneContact returns a copy of the contact that shares no memory with the original.
+func cloneContact(c *models.Contact) *models.Contact {
+	cp := *c
+	if c.DeletedAt != nil {
+		deletedAt := *c.DeletedAt
+		cp.DeletedAt = &deletedAt
+	}
+	return &cp
+}
+
+// cloneContacts returns a deep copy of the contacts slice.
+func cloneContacts(contacts []*models.Contact) []*models.Contact {
+	cp := make([]*models.Contact, len(contacts))
+	for i, c := range contacts {
+		cp[i] = cloneContact(c)
+	}
+	return cp
+}
+
+// commit persists the proposed contacts slice and, only if that succeeds, makes it the current
+// state. A failed write therefore leaves the store unchanged. Callers must hold the write lock.
+func (s *MemoryStore) commit(contacts []*models.Contact) error {
+	if s.persist != nil {
+		if err := s.persist(contacts); err != nil {
+			return err
+		}
+	}
+
+	s.contacts = contacts
+
+	return nil
+}
+
+// getNextID returns the next available ID for a new contact. IDs are unique across every book and
+// never reused, even once the contact that had one is purged, so that a new contact does not take
+// over the history of an old one. Callers must hold a lock.
+func (s *MemoryStore) getNextID() int {
+	return s.lastID + 1
+}
+
+// ReserveIDs makes sure that contacts inserted from now on get an ID above lastID. The contacts
+// the store was created with only tell it about the IDs still in use, so the server passes in the
+// highest ID in the audit log as well.
+func (s *MemoryStore) ReserveIDs(lastID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID = max(s.lastID, lastID)
+
+	return nil
+}
+
+// indexOf returns the position of the contact with the given ID, or -1 if it does not exist,
+// belongs to another book or is in the trash. Callers must hold a lock.
+func (s *MemoryStore) indexOf(id int) int {
+	return slices.IndexFunc(s.contacts, func(c *models.Contact) bool {
+		return c.ID == id && c.BookID == s.book && c.DeletedAt == nil
+	})
+}
+
+// trashIndexOf returns the position of the book's trashed contact with the given ID, or -1.
+// Callers must hold a lock.
+func (s *MemoryStore) trashIndexOf(id int) int {
+	return slices.IndexFunc(s.contacts, func(c *models.Contact) bool {
+		return c.ID == id && c.BookID == s.book && c.DeletedAt != nil
+	})
+}
+
+// Get returns a copy of the contact with the given ID, or models.ErrNoRecord if not found.
+func (s *MemoryStore) Get(id int) (*models.Contact, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.indexOf(id)
+	if i < 0 {
+		return nil, models.ErrNoRecord
+	}
+
+	return cloneContact(s.contacts[i]), nil
+}
+
+// GetByUID returns a copy of the contact with the given UID, or models.ErrNoRecord if not found.
+func (s *MemoryStore) GetByUID(uid string) (*models.Contact, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if uid == "" {
+		return nil, models.ErrNoRecord
+	}
+
+	i := slices.IndexFunc(s.contacts, func(c *models.Contact) bool {
+		return c.UID == uid && c.BookID == s.book && c.DeletedAt == nil
+	})
+	if i < 0 {
+		return nil, models.ErrNoRecord
+	}
+
+	return cloneContact(s.contacts[i]), nil
+}
+
+// GetAll returns copies of all contacts in the store. If a query string is provided, it filters the
+// contacts whose Email, First, Last or Phone includes the query string (case insensitive).
+func (s *MemoryStore) GetAll(query ...string) ([]*models.Contact, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	q := ""
+	if len(query) > 0 {
+		q = query[0]
+	}
+
+	return cloneContacts(s.filter(q)), nil
+}
+
+// List returns copies of one page of the contacts matching params.Query, ordered by params.Sort,
+// and the total number of matches.
+func (s *MemoryStore) List(params ListParams) ([]*models.Contact, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matches := s.filter(params.Query)
+	params.sort(matches)
+
+	return cloneContacts(params.paginate(matches)), len(matches), nil
+}
+
+// filter returns a new slice of the book's contacts whose Email, First, Last or Phone includes the
+// query (case insensitive), or every contact in the book when the query is empty. Callers must hold
+// a lock.
+func (s *MemoryStore) filter(query string) []*models.Contact {
+	q := strings.ToLower(query)
+	var filteredContacts []*models.Contact
+
+	for _, c := range s.contacts {
+		if c.BookID != s.book || c.DeletedAt != nil {
+			continue
+		}
+
+		if q == "" ||
+			strings.Contains(strings.ToLower(c.Email), q) ||
+			strings.Contains(strings.ToLower(c.First), q) ||
+			strings.Contains(strings.ToLower(c.Last), q) ||
+			strings.Contains(strings.ToLower(c.Phone), q) {
+			filteredContacts = append(filteredContacts, c)
+		}
+	}
+
+	return filteredContacts
+}
+
+// Insert adds a new contact to the book and persists the change. The contact's ID and BookID are
+// set to the ones it was stored under.
+// Returns models.ErrDuplicateEmail if the email address is already in use or an error if the change
+// cannot be persisted.
+func (s *MemoryStore) Insert(contact *models.Contact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.emailUnique(contact.Email, 0) {
+		return models.ErrDuplicateEmail
+	}
+
+	stored := cloneContact(contact)
+	stored.ID = s.getNextID()
+	stored.BookID = s.book
+	stored.DeletedAt = nil
+
+	if err := s.commit(append(slices.Clip(s.contacts), stored)); err != nil {
+		return err
+	}
+
+	s.lastID = stored.ID
+	contact.ID = stored.ID
+	contact.BookID = stored.BookID
+
+	return nil
+}
+
+// Update modifies an existing contact in the store and persists the change.
+// Returns models.ErrNoRecord if the contact is not found, models.ErrDuplicateEmail if the email
+// address is used by another contact or an error if the change cannot be persisted.
+func (s *MemoryStore) Update(contact *models.Contact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(contact.ID)
+	if i < 0 {
+		return models.ErrNoRecord
+	}
+
+	if !s.emailUnique(contact.Email, contact.ID) {
+		return models.ErrDuplicateEmail
+	}
+
+	stored := cloneContact(contact)
+	stored.BookID = s.book
+	stored.UID = s.contacts[i].UID
+	stored.DeletedAt = nil
+
+	contacts := slices.Clone(s.contacts)
+	contacts[i] = stored
+
+	if err := s.commit(contacts); err != nil {
+		return err
+	}
+
+	contact.BookID = stored.BookID
+
+	return nil
+}
+
+// SaveMany inserts the contacts with a zero ID and updates the others, persisting the change with a
+// single write. Inserted contacts have their ID and BookID set.
+// Returns models.ErrNoRecord if a contact to update is not found, models.ErrDuplicateEmail if an
+// email address would be used twice or an error if the change cannot be persisted. Nothing is
+// saved in any of these cases.
+func (s *MemoryStore) SaveMany(contacts []*models.Contact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	proposed := slices.Clone(s.contacts)
+	nextID := s.getNextID()
+	ids := make([]int, len(contacts))
+
+	for i, contact := range contacts {
+		stored := cloneContact(contact)
+		stored.BookID = s.book
+		stored.DeletedAt = nil
+
+		if stored.ID == 0 {
+			stored.ID = nextID
+			nextID++
+			proposed = append(proposed, stored)
+		} else {
+			j := s.indexOf(stored.ID)
+			if j < 0 {
+				return models.ErrNoRecord
+			}
+			stored.UID = proposed[j].UID
+			proposed[j] = stored
+		}
+
+		ids[i] = stored.ID
+	}
+
+	// check uniqueness against the proposed state so that clashes within the batch are caught too
+	seen := make(map[string]bool)
+	for _, c := range proposed {
+		if c.BookID != s.book || c.DeletedAt != nil {
+			continue
+		}
+		if seen[c.Email] {
+			return models.ErrDuplicateEmail
+		}
+		seen[c.Email] = true
+	}
+
+	if err := s.commit(proposed); err != nil {
+		return err
+	}
+
+	s.lastID = nextID - 1
+	for i, contact := range contacts {
+		contact.ID = ids[i]
+		contact.BookID = s.book
+	}
+
+	return nil
+}
+
+// Delete moves a contact to the trash by ID and persists the change.
+// Returns models.ErrNoRecord if the contact is not found or an error if the change cannot be persisted.
+func (s *MemoryStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(id)
+	if i < 0 {
+		return models.ErrNoRecord
+	}
+
+	now := time.Now().UTC()
+
+	contacts := slices.Clone(s.contacts)
+	contacts[i] = cloneContact(contacts[i])
+	contacts[i].DeletedAt = &now
+
+	return s.commit(contacts)
+}
+
+// DeleteMany moves every contact whose ID is listed to the trash and persists the change with a
+// single write. Returns the number of contacts moved; unknown IDs are ignored and nothing is written
+// if none match.
+func (s *MemoryStore) DeleteMany(ids []int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	contacts := slices.Clone(s.contacts)
+	removed := 0
+
+	for i, c := range contacts {
+		if c.BookID == s.book && c.DeletedAt == nil && slices.Contains(ids, c.ID) {
+			contacts[i] = cloneContact(c)
+			contacts[i].DeletedAt = &now
+			removed++
+		}
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+
+	if err := s.commit(contacts); err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// Trash returns copies of the book's contacts in the trash, most recently deleted first.
+func (s *MemoryStore) Trash() ([]*models.Contact, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var trashed []*models.Contact
+	for _, c := range s.contacts {
+		if c.BookID == s.book && c.DeletedAt != nil {
+			trashed = append(trashed, cloneContact(c))
+		}
+	}
+
+	slices.SortStableFunc(trashed, func(a, b *models.Contact) int {
+		return b.DeletedAt.Compare(*a.DeletedAt)
+	})
+
+	return trashed, nil
+}
+
+// Restore takes a contact out of the trash and persists the change.
+// Returns models.ErrNoRecord if the contact is not in the trash, models.ErrDuplicateEmail if its
+// email address is now used by another contact or an error if the change cannot be persisted.
+func (s *MemoryStore) Restore(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.trashIndexOf(id)
+	if i < 0 {
+		return models.ErrNoRecord
+	}
+
+	if !s.emailUnique(s.contacts[i].Email, id) {
+		return models.ErrDuplicateEmail
+	}
+
+	contacts := slices.Clone(s.contacts)
+	contacts[i] = cloneContact(contacts[i])
+	contacts[i].DeletedAt = nil
+
+	return s.commit(contacts)
+}
+
+// Purge permanently removes a contact from the trash and persists the change.
+// Returns models.ErrNoRecord if the contact is not in the trash or an error if the change cannot be
+// persisted.
+func (s *MemoryStore) Purge(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.trashIndexOf(id)
+	if i < 0 {
+		return models.ErrNoRecord
+	}
+
+	return s.commit(slices.Delete(slices.Clone(s.contacts), i, i+1))
+}
+
+// PurgeExpired permanently removes the contacts of every book that were moved to the trash before
+// the cutoff, persists the change with a single write and returns copies of the removed contacts.
+// Nothing is written if none have expired.
+func (s *MemoryStore) PurgeExpired(before time.Time) ([]*models.Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged []*models.Contact
+	contacts := slices.DeleteFunc(slices.Clone(s.contacts), func(c *models.Contact) bool {
+		if c.DeletedAt != nil && c.DeletedAt.Before(before) {
+			purged = append(purged, cloneContact(c))
+			return true
+		}
+		return false
+	})
+
+	if len(purged) == 0 {
+		return nil, nil
+	}
+
+	if err := s.commit(contacts); err != nil {
+		return nil, err
+	}
+
+	return purged, nil
+}
+
+// EmailUnique checks that no contact in the book other than the one with the given ID uses the
+// email address. Contacts in the trash are ignored.
+func (s *MemoryStore) EmailUnique(email string, id int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.emailUnique(email, id)
+}
+
+// emailUnique is the lock-free implementation of EmailUnique. Callers must hold a lock.
+func (s *MemoryStore) emailUnique(email string, id int) bool {
+	for _, c := range s.contacts {
+		if c.BookID == s.book && c.DeletedAt == nil && c.Email == email && c.ID != id {
+			return false
+		}
+	}
+	return true
+}
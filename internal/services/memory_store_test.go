@@ -0,0 +1,11 @@
+package services
+
+import "testing"
+
+func TestMemoryStoreConcurrentUse(t *testing.T) {
+	testConcurrentUse(t, NewMemoryStore(nil))
+}
+
+func TestMemoryStoreIDsNotReused(t *testing.T) {
+	testIDsNotReused(t, NewMemoryStore(nil))
+}
@@ -0,0 +1,9 @@
+CREATE TABLE contacts (
+    id    INTEGER PRIMARY KEY AUTOINCREMENT,
+    first TEXT NOT NULL DEFAULT '',
+    last  TEXT NOT NULL DEFAULT '',
+    phone TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_contacts_email ON contacts (email);
@@ -0,0 +1,5 @@
+ALTER TABLE contacts ADD COLUMN book_id INTEGER NOT NULL DEFAULT 1;
+
+-- email addresses only need to be unique within an address book
+DROP INDEX idx_contacts_email;
+CREATE UNIQUE INDEX idx_contacts_book_email ON contacts (book_id, email);
@@ -0,0 +1,6 @@
+ALTER TABLE contacts ADD COLUMN deleted_at TEXT;
+
+-- contacts in the trash no longer reserve their email address
+DROP INDEX idx_contacts_book_email;
+CREATE UNIQUE INDEX idx_contacts_book_email ON contacts (book_id, email) WHERE deleted_at IS NULL;
+CREATE INDEX idx_contacts_deleted_at ON contacts (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE contacts ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+
+-- CardDAV clients address the contacts they created by UID
+CREATE INDEX idx_contacts_book_uid ON contacts (book_id, uid) WHERE uid <> '';
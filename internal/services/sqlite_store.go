@@ -0,0 +1,477 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // pure-Go SQLite driver, no cgo required
+)
+
+// SQLiteStore is a ContactStore backed by a SQLite database. Every address book shares the
+// contacts table; each store works on the rows of one book.
+type SQLiteStore struct {
+	db   *sql.DB
+	book int
+}
+
+// OpenSQLiteStore opens (creating if necessary) the SQLite database at path, applies any pending
+// schema migrations and returns a store ready for use.
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)", path)
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db, book: models.DefaultBookID}, nil
+}
+
+// InBook returns a store that works on the contacts of the given address book using the same
+// database connection.
+func (s *SQLiteStore) InBook(bookID int) ContactStore {
+	return &SQLiteStore{db: s.db, book: bookID}
+}
+
+// Close closes the underlying database, and with it every store returned by InBook.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// isUniqueViolation reports whether err was caused by a UNIQUE constraint failing.
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// contactColumns is the column list selected by every contact query, in scanContact order.
+const contactColumns = "id, book_id, uid, first, last, phone, email, deleted_at"
+
+// sqliteTimeLayout formats timestamps stored as TEXT. The fixed width keeps them in chronological
+// order when compared as strings.
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// formatTime converts a time to the stored TEXT representation.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+// scanContact reads a row selected with contactColumns.
+func scanContact(row interface{ Scan(...any) error }) (*models.Contact, error) {
+	c := &models.Contact{}
+	var deletedAt sql.NullString
+
+	if err := row.Scan(&c.ID, &c.BookID, &c.UID, &c.First, &c.Last, &c.Phone, &c.Email, &deletedAt); err != nil {
+		return nil, err
+	}
+
+	if deletedAt.Valid {
+		t, err := time.Parse(sqliteTimeLayout, deletedAt.String)
+		if err != nil {
+			return nil, err
+		}
+		c.DeletedAt = &t
+	}
+
+	return c, nil
+}
+
+// Get returns a contact by ID if found, or models.ErrNoRecord if not found.
+func (s *SQLiteStore) Get(id int) (*models.Contact, error) {
+	c, err := scanContact(s.db.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE id = ? AND book_id = ? AND deleted_at IS NULL", id, s.book))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// contactByUIDQuery selects a book's contact by UID. Requiring a non-empty uid matches the
+// condition on the partial index idx_contacts_book_uid, without which SQLite would not use it.
+const contactByUIDQuery = "SELECT " + contactColumns + " FROM contacts WHERE book_id = ? AND uid = ? AND uid <> '' AND deleted_at IS NULL ORDER BY id LIMIT 1"
+
+// GetByUID returns a contact by UID if found, or models.ErrNoRecord if not found.
+func (s *SQLiteStore) GetByUID(uid string) (*models.Contact, error) {
+	c, err := scanContact(s.db.QueryRow(contactByUIDQuery, s.book, uid))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// searchClause returns the WHERE clause and arguments that select the book's contacts outside the
+// trash, filtered by a search query when there is one.
+func (s *SQLiteStore) searchClause(query string) (string, []any) {
+	if query == "" {
+		return " WHERE book_id = ?1 AND deleted_at IS NULL", []any{s.book}
+	}
+
+	return ` WHERE book_id = ?1 AND deleted_at IS NULL AND (instr(lower(email), ?2) > 0
+		OR instr(lower(first), ?2) > 0
+		OR instr(lower(last), ?2) > 0
+		OR instr(lower(phone), ?2) > 0)`, []any{s.book, strings.ToLower(query)}
+}
+
+// orderClause returns the ORDER BY expression for params.Sort. The column name comes from the
+// sortFields whitelist, never from user input, and ties are broken by ID.
+func orderClause(params ListParams) string {
+	field, desc := params.sortKey()
+	if field == "" {
+		return "id"
+	}
+
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+
+	return fmt.Sprintf("lower(%s) %s, id", field, dir)
+}
+
+// queryContacts runs a contact SELECT and scans every row.
+func (s *SQLiteStore) queryContacts(stmt string, args ...any) ([]*models.Contact, error) {
+	rows, err := s.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []*models.Contact
+
+	for rows.Next() {
+		c, err := scanContact(rows)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, c)
+	}
+
+	return contacts, rows.Err()
+}
+
+// GetAll returns all contacts ordered by ID. If a query string is provided, it filters the contacts
+// whose Email, First, Last or Phone includes the query string (case insensitive).
+func (s *SQLiteStore) GetAll(query ...string) ([]*models.Contact, error) {
+	q := ""
+	if len(query) > 0 {
+		q = query[0]
+	}
+
+	where, args := s.searchClause(q)
+
+	return s.queryContacts("SELECT "+contactColumns+" FROM contacts"+where+" ORDER BY id", args...)
+}
+
+// List returns one page of the contacts matching params.Query, ordered by params.Sort, and the total
+// number of matches.
+func (s *SQLiteStore) List(params ListParams) ([]*models.Contact, int, error) {
+	where, args := s.searchClause(params.Query)
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM contacts"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	stmt := "SELECT " + contactColumns + " FROM contacts" + where + " ORDER BY " + orderClause(params)
+	if params.Size > 0 {
+		stmt += fmt.Sprintf(" LIMIT %d OFFSET %d", params.Size, params.offset())
+	}
+
+	contacts, err := s.queryContacts(stmt, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return contacts, total, nil
+}
+
+// Insert adds a new contact to the book and sets its ID to the one assigned by the database.
+// Returns models.ErrDuplicateEmail if the email address is already in use.
+func (s *SQLiteStore) Insert(contact *models.Contact) error {
+	result, err := s.db.Exec(
+		"INSERT INTO contacts (book_id, uid, first, last, phone, email) VALUES (?, ?, ?, ?, ?, ?)",
+		s.book, contact.UID, contact.First, contact.Last, contact.Phone, contact.Email,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return models.ErrDuplicateEmail
+		}
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	contact.ID = int(id)
+	contact.BookID = s.book
+
+	return nil
+}
+
+// ReserveIDs makes sure that contacts inserted from now on get an ID above lastID. The contacts
+// table uses AUTOINCREMENT, so SQLite already never reuses an ID within one database; this only
+// matters when the database is newer than the audit log.
+func (s *SQLiteStore) ReserveIDs(lastID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// sqlite_sequence has no row for the table until the first insert
+	if _, err := tx.Exec(
+		"INSERT INTO sqlite_sequence (name, seq) SELECT 'contacts', 0 WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'contacts')",
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE sqlite_sequence SET seq = MAX(seq, ?) WHERE name = 'contacts'", lastID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Update modifies an existing contact.
+// Returns models.ErrNoRecord if the contact is not found or models.ErrDuplicateEmail if the email
+// address is used by another contact.
+func (s *SQLiteStore) Update(contact *models.Contact) error {
+	result, err := s.db.Exec(
+		"UPDATE contacts SET first = ?, last = ?, phone = ?, email = ? WHERE id = ? AND book_id = ? AND deleted_at IS NULL",
+		contact.First, contact.Last, contact.Phone, contact.Email, contact.ID, s.book,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return models.ErrDuplicateEmail
+		}
+		return err
+	}
+
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+
+	contact.BookID = s.book
+
+	return nil
+}
+
+// SaveMany inserts the contacts with a zero ID and updates the others in a single transaction.
+// Inserted contacts have their ID and BookID set.
+// Returns models.ErrNoRecord if a contact to update is not found or models.ErrDuplicateEmail if an
+// email address would be used twice. Nothing is saved in either case.
+func (s *SQLiteStore) SaveMany(contacts []*models.Contact) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare("INSERT INTO contacts (book_id, uid, first, last, phone, email) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	update, err := tx.Prepare("UPDATE contacts SET first = ?, last = ?, phone = ?, email = ? WHERE id = ? AND book_id = ? AND deleted_at IS NULL")
+	if err != nil {
+		return err
+	}
+	defer update.Close()
+
+	ids := make([]int, len(contacts))
+
+	for i, c := range contacts {
+		if c.ID == 0 {
+			result, err := insert.Exec(s.book, c.UID, c.First, c.Last, c.Phone, c.Email)
+			if err != nil {
+				if isUniqueViolation(err) {
+					return models.ErrDuplicateEmail
+				}
+				return err
+			}
+
+			id, err := result.LastInsertId()
+			if err != nil {
+				return err
+			}
+			ids[i] = int(id)
+			continue
+		}
+
+		result, err := update.Exec(c.First, c.Last, c.Phone, c.Email, c.ID, s.book)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return models.ErrDuplicateEmail
+			}
+			return err
+		}
+
+		if err := requireAffected(result); err != nil {
+			return err
+		}
+		ids[i] = c.ID
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for i, c := range contacts {
+		c.ID = ids[i]
+		c.BookID = s.book
+	}
+
+	return nil
+}
+
+// Delete moves a contact to the trash by ID.
+// Returns models.ErrNoRecord if the contact is not found.
+func (s *SQLiteStore) Delete(id int) error {
+	result, err := s.db.Exec(
+		"UPDATE contacts SET deleted_at = ? WHERE id = ? AND book_id = ? AND deleted_at IS NULL",
+		formatTime(time.Now()), id, s.book,
+	)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// DeleteMany moves every contact whose ID is listed to the trash in a single transaction.
+// Returns the number of contacts moved; unknown IDs are ignored.
+func (s *SQLiteStore) DeleteMany(ids []int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE contacts SET deleted_at = ? WHERE id = ? AND book_id = ? AND deleted_at IS NULL")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	now := formatTime(time.Now())
+	removed := 0
+
+	for _, id := range ids {
+		result, err := stmt.Exec(now, id, s.book)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		removed += int(n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// EmailUnique checks that no contact in the book other than the one with the given ID uses the
+// email address, ignoring contacts in the trash. Database errors are treated as "not unique" so that validation fails closed.
+func (s *SQLiteStore) EmailUnique(email string, id int) bool {
+	var exists bool
+
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM contacts WHERE book_id = ? AND deleted_at IS NULL AND email = ? AND id <> ?)", s.book, email, id).Scan(&exists)
+	if err != nil {
+		return false
+	}
+
+	return !exists
+}
+
+// Trash returns the book's contacts in the trash, most recently deleted first.
+func (s *SQLiteStore) Trash() ([]*models.Contact, error) {
+	return s.queryContacts(
+		"SELECT "+contactColumns+" FROM contacts WHERE book_id = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, id",
+		s.book,
+	)
+}
+
+// Restore takes a contact out of the trash.
+// Returns models.ErrNoRecord if the contact is not in the trash or models.ErrDuplicateEmail if its
+// email address is now used by another contact.
+func (s *SQLiteStore) Restore(id int) error {
+	result, err := s.db.Exec(
+		"UPDATE contacts SET deleted_at = NULL WHERE id = ? AND book_id = ? AND deleted_at IS NOT NULL",
+		id, s.book,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return models.ErrDuplicateEmail
+		}
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// Purge permanently removes a contact from the trash.
+// Returns models.ErrNoRecord if the contact is not in the trash.
+func (s *SQLiteStore) Purge(id int) error {
+	result, err := s.db.Exec("DELETE FROM contacts WHERE id = ? AND book_id = ? AND deleted_at IS NOT NULL", id, s.book)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// PurgeExpired permanently removes the contacts of every book that were moved to the trash before
+// the cutoff and returns them.
+func (s *SQLiteStore) PurgeExpired(before time.Time) ([]*models.Contact, error) {
+	return s.queryContacts(
+		"DELETE FROM contacts WHERE deleted_at IS NOT NULL AND deleted_at < ? RETURNING "+contactColumns,
+		formatTime(before),
+	)
+}
+
+// requireAffected returns models.ErrNoRecord if the statement did not change any rows.
+func requireAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// openTestSQLiteStore opens a new SQLite store in a temporary directory, closed when the test ends.
+func openTestSQLiteStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+
+	store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "contacts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func TestSQLiteStoreConcurrentUse(t *testing.T) {
+	testConcurrentUse(t, openTestSQLiteStore(t))
+}
+
+func TestSQLiteStoreIDsNotReused(t *testing.T) {
+	testIDsNotReused(t, openTestSQLiteStore(t))
+}
+
+func TestSQLiteStoreGetByUIDUsesIndex(t *testing.T) {
+	store := openTestSQLiteStore(t)
+
+	rows, err := store.db.Query("EXPLAIN QUERY PLAN "+contactByUIDQuery, 1, "uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, detail)
+	}
+
+	if !slices.ContainsFunc(plan, func(d string) bool { return strings.Contains(d, "idx_contacts_book_uid") }) {
+		t.Errorf("query plan does not use idx_contacts_book_uid: %q", plan)
+	}
+}
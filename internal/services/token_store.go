@@ -0,0 +1,206 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// tokenPrefix marks plaintext API tokens so they are easy to recognise in scripts and secret scanners.
+const tokenPrefix = "cat_"
+
+// TokenStore manages API tokens in a JSON file kept alongside the contact data. Only SHA-256 hashes
+// of the secrets are written to disk. It is safe for concurrent use.
+//
+// Tokens are created and revoked by the token subcommand, a separate process, so the file is read
+// again whenever it has changed since the store last read or wrote it. A revoked token stops
+// working on the running server with the next request.
+type TokenStore struct {
+	mu     sync.Mutex
+	path   string
+	tokens []*models.Token
+	loaded os.FileInfo
+}
+
+// OpenTokenStore loads the tokens stored at path. A missing file yields an empty store that is created
+// on the first write, and an empty path keeps the tokens in memory only.
+func OpenTokenStore(path string) (*TokenStore, error) {
+	s := &TokenStore{path: path}
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// reload reads the file again if it was replaced, changed or removed since it was last read or
+// written. Callers must hold the lock.
+func (s *TokenStore) reload() error {
+	if s.path == "" {
+		return nil
+	}
+
+	// stat before reading, so that a change made in between is picked up by the next call
+	info, err := os.Stat(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if !fileChanged(info, s.loaded) {
+		return nil
+	}
+
+	var tokens []*models.Token
+	if err := loadJSONFile(s.path, &tokens); err != nil {
+		return err
+	}
+
+	s.tokens = tokens
+	s.loaded = info
+
+	return nil
+}
+
+// current returns the up-to-date list of tokens. The list is never modified in place, so it can
+// be read without holding the lock.
+func (s *TokenStore) current() ([]*models.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	return s.tokens, nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a plaintext token.
+func hashToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// save writes the proposed tokens to disk and, if that succeeds, makes them current. Callers must
+// hold the lock.
+func (s *TokenStore) save(tokens []*models.Token) error {
+	if s.path != "" {
+		if err := saveJSONFile(s.path, tokens, 0o600); err != nil {
+			return err
+		}
+
+		info, err := os.Stat(s.path)
+		if err != nil {
+			return err
+		}
+		s.loaded = info
+	}
+
+	s.tokens = tokens
+
+	return nil
+}
+
+// Create generates a new token with the given name and scopes that works on the given address book
+// (0 for the default book). The plaintext secret is returned once and cannot be recovered later.
+func (s *TokenStore) Create(name string, scopes []string, bookID int) (string, *models.Token, error) {
+	for _, scope := range scopes {
+		if !slices.Contains(models.Scopes, scope) {
+			return "", nil, fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+
+	plaintext := tokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return "", nil, err
+	}
+
+	id := 1
+	for _, t := range s.tokens {
+		id = max(id, t.ID+1)
+	}
+
+	token := &models.Token{
+		ID:        id,
+		Name:      name,
+		Hash:      hashToken(plaintext),
+		Scopes:    slices.Clone(scopes),
+		BookID:    bookID,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if err := s.save(append(slices.Clip(s.tokens), token)); err != nil {
+		return "", nil, err
+	}
+
+	return plaintext, token, nil
+}
+
+// Revoke deletes the token with the given ID. Returns models.ErrNoRecord if it does not exist.
+func (s *TokenStore) Revoke(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(s.tokens, func(t *models.Token) bool { return t.ID == id })
+	if i < 0 {
+		return models.ErrNoRecord
+	}
+
+	return s.save(slices.Delete(slices.Clone(s.tokens), i, i+1))
+}
+
+// All returns every token, without their secrets, ordered by ID.
+func (s *TokenStore) All() ([]*models.Token, error) {
+	current, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*models.Token, len(current))
+	for i, t := range current {
+		cp := *t
+		tokens[i] = &cp
+	}
+
+	return tokens, nil
+}
+
+// Authenticate returns the token matching the plaintext secret, or models.ErrInvalidToken.
+// Returns another error if the token file has changed and cannot be read.
+func (s *TokenStore) Authenticate(plaintext string) (*models.Token, error) {
+	hash := hashToken(plaintext)
+
+	tokens, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tokens {
+		if t.Hash == hash {
+			cp := *t
+			return &cp, nil
+		}
+	}
+
+	return nil, models.ErrInvalidToken
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"errors"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"path/filepath"
+	"testing"
+)
+
+func TestTokenStoreSeesChangesFromAnotherStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+
+	// server stands for the running server and cli for the token subcommand
+	server, err := OpenTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := OpenTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, token, err := cli.Create("script", []string{models.ScopeContactsRead}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Authenticate(plaintext); err != nil {
+		t.Fatalf("new token: got %v; want it to authenticate", err)
+	}
+
+	if err := cli.Revoke(token.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Authenticate(plaintext); !errors.Is(err, models.ErrInvalidToken) {
+		t.Fatalf("revoked token: got %v; want %v", err, models.ErrInvalidToken)
+	}
+}
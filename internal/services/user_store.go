@@ -0,0 +1,257 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// passwordCost is the bcrypt work factor used when hashing passwords. It is a variable so that
+// tests can lower it.
+var passwordCost = 12
+
+// UserStore manages user accounts in a JSON file kept alongside the contact data. Passwords are
+// stored as bcrypt hashes. It is safe for concurrent use.
+//
+// Roles are changed by the user subcommand, a separate process, so like TokenStore the file is
+// read again whenever it has changed since the store last read or wrote it. A new role applies on
+// the running server from the next request, and the server's own writes never undo it.
+type UserStore struct {
+	mu     sync.Mutex
+	path   string
+	users  []*models.User
+	loaded os.FileInfo
+}
+
+// OpenUserStore loads the users stored at path. A missing file yields an empty store that is created
+// on the first write, and an empty path keeps the users in memory only.
+func OpenUserStore(path string) (*UserStore, error) {
+	s := &UserStore{path: path}
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// reload reads the file again if it was replaced, changed or removed since it was last read or
+// written. Callers must hold the lock.
+func (s *UserStore) reload() error {
+	if s.path == "" {
+		return nil
+	}
+
+	// stat before reading, so that a change made in between is picked up by the next call
+	info, err := os.Stat(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if !fileChanged(info, s.loaded) {
+		return nil
+	}
+
+	var users []*models.User
+	if err := loadJSONFile(s.path, &users); err != nil {
+		return err
+	}
+
+	s.users = users
+	s.loaded = info
+
+	return nil
+}
+
+// current returns the up-to-date list of users. The list is never modified in place, so it can be
+// read without holding the lock.
+func (s *UserStore) current() ([]*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	return s.users, nil
+}
+
+// save writes the proposed users to disk and, if that succeeds, makes them current. Callers must
+// hold the lock.
+func (s *UserStore) save(users []*models.User) error {
+	if s.path != "" {
+		if err := saveJSONFile(s.path, users, 0o600); err != nil {
+			return err
+		}
+
+		info, err := os.Stat(s.path)
+		if err != nil {
+			return err
+		}
+		s.loaded = info
+	}
+
+	s.users = users
+
+	return nil
+}
+
+// findUser returns the user with a matching email address (case insensitive), or nil.
+func findUser(users []*models.User, email string) *models.User {
+	for _, u := range users {
+		if strings.EqualFold(u.Email, email) {
+			return u
+		}
+	}
+	return nil
+}
+
+// Insert creates a new user with a hashed copy of the password and returns its ID. Every user
+// starts as a viewer, the first one included, so that whoever reaches the signup page first on a
+// new server does not get to run it; admins are made with the user role command.
+// Returns models.ErrDuplicateEmail if the email address is already registered.
+func (s *UserStore) Insert(name, email, password string) (int, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return 0, err
+	}
+
+	if findUser(s.users, email) != nil {
+		return 0, models.ErrDuplicateEmail
+	}
+
+	id := 1
+	for _, u := range s.users {
+		id = max(id, u.ID+1)
+	}
+
+	user := &models.User{
+		ID:             id,
+		Name:           name,
+		Email:          email,
+		HashedPassword: hashedPassword,
+		Role:           models.RoleViewer,
+		CreatedAt:      time.Now().UTC(),
+	}
+
+	if err := s.save(append(slices.Clip(s.users), user)); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// Authenticate checks an email address and password and returns the matching user's ID.
+// Returns models.ErrInvalidCredentials if the user does not exist or the password is wrong.
+func (s *UserStore) Authenticate(email, password string) (int, error) {
+	users, err := s.current()
+	if err != nil {
+		return 0, err
+	}
+
+	user := findUser(users, email)
+	if user == nil {
+		return 0, models.ErrInvalidCredentials
+	}
+
+	err = bcrypt.CompareHashAndPassword(user.HashedPassword, []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return 0, models.ErrInvalidCredentials
+		}
+		return 0, err
+	}
+
+	return user.ID, nil
+}
+
+// Get returns a copy of the user with the given ID, or models.ErrNoRecord if not found.
+func (s *UserStore) Get(id int) (*models.User, error) {
+	users, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range users {
+		if u.ID == id {
+			cp := *u
+			return &cp, nil
+		}
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+// GetByEmail returns a copy of the user registered with the email address (case insensitive), or
+// models.ErrNoRecord if there is none.
+func (s *UserStore) GetByEmail(email string) (*models.User, error) {
+	users, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+
+	user := findUser(users, email)
+	if user == nil {
+		return nil, models.ErrNoRecord
+	}
+
+	cp := *user
+	return &cp, nil
+}
+
+// SetRole changes the role of the user registered with the email address (case insensitive).
+// Returns models.ErrNoRecord if there is no such user.
+func (s *UserStore) SetRole(email, role string) error {
+	if !slices.Contains(models.Roles, role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(s.users, func(u *models.User) bool { return strings.EqualFold(u.Email, email) })
+	if i < 0 {
+		return models.ErrNoRecord
+	}
+
+	user := *s.users[i]
+	user.Role = role
+
+	users := slices.Clone(s.users)
+	users[i] = &user
+
+	return s.save(users)
+}
+
+// All returns a copy of every user ordered by ID.
+func (s *UserStore) All() ([]*models.User, error) {
+	current, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*models.User, len(current))
+	for i, u := range current {
+		cp := *u
+		users[i] = &cp
+	}
+
+	return users, nil
+}
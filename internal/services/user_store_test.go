@@ -0,0 +1,87 @@
+package services
+
+import (
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"golang.org/x/crypto/bcrypt"
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	// hashing at the production cost takes seconds under the race detector
+	passwordCost = bcrypt.MinCost
+}
+
+func TestUserStoreFirstUserIsViewer(t *testing.T) {
+	store, err := OpenUserStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := store.Insert("First", "first@example.com", "longenough")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := store.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != models.RoleViewer {
+		t.Errorf("got role %q for the first user; want %q", user.Role, models.RoleViewer)
+	}
+}
+
+func TestUserStoreSeesChangesFromAnotherStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+
+	// server stands for the running server and cli for the user subcommand
+	server, err := OpenUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := OpenUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := server.Insert("Admin", "admin@example.com", "longenough")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cli.SetRole("admin@example.com", models.RoleAdmin); err != nil {
+		t.Fatalf("promoting a user the server created: %v", err)
+	}
+
+	user, err := server.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != models.RoleAdmin {
+		t.Fatalf("after promotion: got role %q; want %q", user.Role, models.RoleAdmin)
+	}
+
+	if err := cli.SetRole("admin@example.com", models.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	// a signup on the server must not write the old role back
+	if _, err := server.Insert("Other", "other@example.com", "longenough"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range map[string]*UserStore{"server": server, "cli": cli} {
+		user, err := store.GetByEmail("admin@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != models.RoleViewer {
+			t.Errorf("%s after demotion and signup: got role %q; want %q", name, user.Role, models.RoleViewer)
+		}
+
+		if _, err := store.Authenticate("other@example.com", "longenough"); err != nil {
+			t.Errorf("%s: new user does not authenticate: %v", name, err)
+		}
+	}
+}
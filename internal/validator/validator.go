@@ -1,19 +1,25 @@
 package validator
 
 import (
+	"regexp"
 	"slices"
 	"strings"
 	"unicode/utf8"
 )
 
+// EmailRX is a regular expression for sanity checking the format of an email address.
+// ref: https://html.spec.whatwg.org/#valid-e-mail-address
+var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
 // Validator represents a validation object that holds validation errors.
 type Validator struct {
-	Errors map[string]string // A map to store validation errors.
+	NonFieldErrors []string          // Errors that are not related to a specific field.
+	Errors         map[string]string // A map to store validation errors.
 }
 
 // Valid checks if there are no validation errors.
 func (v *Validator) Valid() bool {
-	return len(v.Errors) == 0
+	return len(v.Errors) == 0 && len(v.NonFieldErrors) == 0
 }
 
 // AddError adds an error message for a specific field.
@@ -30,6 +36,11 @@ func (v *Validator) AddError(field, message string) {
 	v.Errors[field] = message
 }
 
+// AddNonFieldError adds an error message that is not tied to a specific field, such as a failed login.
+func (v *Validator) AddNonFieldError(message string) {
+	v.NonFieldErrors = append(v.NonFieldErrors, message)
+}
+
 // CheckField adds an error message if the validation check fails.
 func (v *Validator) CheckField(ok bool, key, message string) {
 	if !ok {
@@ -42,6 +53,16 @@ func NotBlank(value string) bool {
 	return strings.TrimSpace(value) != ""
 }
 
+// MinChars checks if a string contains at least a specified number of characters.
+func MinChars(value string, n int) bool {
+	return utf8.RuneCountInString(value) >= n
+}
+
+// Matches checks if a string matches a regular expression.
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
+
 // MaxChars checks if a string contains no more than a specified number of characters.
 func MaxChars(value string, n int) bool {
 	return utf8.RuneCountInString(value) <= n
@@ -0,0 +1,397 @@
+// Package vcard reads and writes contacts in the vCard format. Writing produces vCard 4.0
+// (RFC 6350) or, for older clients, 3.0 (RFC 2426); reading accepts both and only looks at the
+// properties the contacts app stores: UID, FN, N, TEL and EMAIL.
+package vcard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ContentType is the media type of vCard data.
+const ContentType = "text/vcard; charset=utf-8"
+
+// Versions of the vCard format that can be written.
+const (
+	Version3 = "3.0"
+	Version4 = "4.0"
+)
+
+// maxLineOctets is the longest a content line may be before it is folded, not counting the CRLF.
+const maxLineOctets = 75
+
+// ErrNoCards is returned by Parse when the input does not contain a single vCard.
+var ErrNoCards = errors.New("vcard: no BEGIN:VCARD found")
+
+// Write encodes the contact as a vCard 4.0 object.
+func Write(w io.Writer, c *models.Contact) error {
+	return WriteVersion(w, c, Version4)
+}
+
+// WriteVersion encodes the contact as a vCard object of the given version, Version3 or Version4.
+// The UID property is only written for contacts that have one.
+func WriteVersion(w io.Writer, c *models.Contact, version string) error {
+	if version != Version3 && version != Version4 {
+		return fmt.Errorf("vcard: unsupported version %q", version)
+	}
+
+	bw := bufio.NewWriter(w)
+
+	lines := []string{
+		"BEGIN:VCARD",
+		"VERSION:" + version,
+	}
+	if c.UID != "" {
+		lines = append(lines, "UID:"+escape(c.UID))
+	}
+	lines = append(lines,
+		"FN:"+escape(strings.TrimSpace(c.First+" "+c.Last)),
+		"N:"+escape(c.Last)+";"+escape(c.First)+";;;",
+	)
+	if c.Phone != "" {
+		if version == Version3 {
+			lines = append(lines, "TEL:"+escape(c.Phone))
+		} else {
+			// the default TEL value type in 4.0 is a tel: URI, which free-form numbers rarely are
+			lines = append(lines, "TEL;VALUE=text:"+escape(c.Phone))
+		}
+	}
+	if c.Email != "" {
+		lines = append(lines, "EMAIL:"+escape(c.Email))
+	}
+	lines = append(lines, "END:VCARD")
+
+	for _, line := range lines {
+		if _, err := bw.WriteString(fold(line)); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// WriteAll encodes each contact as a vCard 4.0 object, one after another.
+func WriteAll(w io.Writer, contacts []*models.Contact) error {
+	for _, c := range contacts {
+		if err := Write(w, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// escape backslash-escapes the characters that are special in vCard text values.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// fold splits a content line into CRLF-terminated lines of at most maxLineOctets octets, starting
+// each continuation with a space and never splitting a UTF-8 sequence.
+func fold(line string) string {
+	var b strings.Builder
+
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+
+		// the leading space of a continuation line counts towards its length
+		limit = maxLineOctets - 1
+	}
+
+	b.WriteString(line)
+	b.WriteString("\r\n")
+
+	return b.String()
+}
+
+// property is one unfolded content line.
+type property struct {
+	name   string
+	params map[string][]string
+	value  string
+}
+
+// preferred reports whether the property is marked as the preferred one of its kind: PREF=1 in
+// vCard 4.0 or TYPE=pref in 3.0.
+func (p property) preferred() bool {
+	for _, v := range p.params["PREF"] {
+		if v == "1" {
+			return true
+		}
+	}
+
+	for _, v := range p.params["TYPE"] {
+		if strings.EqualFold(v, "pref") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parse reads every vCard in r and returns a contact for each one. First and Last come from the
+// given and family name components of N, falling back to splitting FN at its last space. Phone and
+// Email take the preferred TEL and EMAIL, or else the first one, and UID is copied as is. Unknown
+// properties are ignored. The returned contacts have no ID.
+func Parse(r io.Reader) ([]*models.Contact, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		contacts []*models.Contact
+		card     []property
+		inCard   bool
+	)
+
+	for n, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		prop, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("vcard: line %d: %w", n+1, err)
+		}
+
+		switch {
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VCARD"):
+			if inCard {
+				return nil, fmt.Errorf("vcard: line %d: nested BEGIN:VCARD", n+1)
+			}
+			inCard, card = true, nil
+		case prop.name == "END" && strings.EqualFold(prop.value, "VCARD"):
+			if !inCard {
+				return nil, fmt.Errorf("vcard: line %d: END:VCARD without BEGIN:VCARD", n+1)
+			}
+			contacts = append(contacts, toContact(card))
+			inCard = false
+		case inCard:
+			card = append(card, prop)
+		}
+	}
+
+	if inCard {
+		return nil, errors.New("vcard: missing END:VCARD")
+	}
+
+	if len(contacts) == 0 {
+		return nil, ErrNoCards
+	}
+
+	return contacts, nil
+}
+
+// unfold reads r and joins folded lines, accepting both CRLF and bare LF line endings.
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+
+		if len(lines) > 0 && line != "" && (line[0] == ' ' || line[0] == '\t') {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("vcard: %w", err)
+	}
+
+	if len(lines) > 0 {
+		lines[0] = strings.TrimPrefix(lines[0], "\ufeff")
+	}
+
+	return lines, nil
+}
+
+// parseLine splits an unfolded content line of the form [group.]name *(;param) : value.
+func parseLine(line string) (property, error) {
+	prop := property{params: make(map[string][]string)}
+
+	// the value starts at the first colon that is not inside a quoted parameter value
+	colon, quoted := -1, false
+	for i := 0; i < len(line) && colon < 0; i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if !quoted {
+				colon = i
+			}
+		}
+	}
+	if colon < 0 {
+		return prop, errors.New("missing ':'")
+	}
+
+	head := splitUnquoted(line[:colon], ';')
+	prop.value = line[colon+1:]
+
+	name := head[0]
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" {
+		return prop, errors.New("missing property name")
+	}
+	prop.name = strings.ToUpper(name)
+
+	for _, param := range head[1:] {
+		key, value, ok := strings.Cut(param, "=")
+		key = strings.ToUpper(key)
+		if !ok {
+			// vCard 2.1 style bare types, such as TEL;CELL
+			key, value = "TYPE", param
+		}
+
+		for _, v := range splitUnquoted(value, ',') {
+			prop.params[key] = append(prop.params[key], strings.Trim(v, `"`))
+		}
+	}
+
+	return prop, nil
+}
+
+// splitUnquoted splits s at every sep that is not inside double quotes.
+func splitUnquoted(s string, sep byte) []string {
+	var (
+		parts  []string
+		start  int
+		quoted bool
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// splitComponents splits a structured value such as N at unescaped semicolons and unescapes each
+// component.
+func splitComponents(value string) []string {
+	var (
+		parts []string
+		b     strings.Builder
+	)
+
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value):
+			b.WriteByte(value[i])
+			b.WriteByte(value[i+1])
+			i++
+		case value[i] == ';':
+			parts = append(parts, unescape(b.String()))
+			b.Reset()
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+
+	return append(parts, unescape(b.String()))
+}
+
+// unescape reverses escape. Unknown escapes keep the escaped character.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
+
+// toContact builds a contact from the properties of one vCard.
+func toContact(props []property) *models.Contact {
+	c := &models.Contact{}
+
+	var fn string
+	var phone, email *property
+
+	for i := range props {
+		p := &props[i]
+
+		switch p.name {
+		case "UID":
+			c.UID = strings.TrimSpace(unescape(p.value))
+		case "FN":
+			fn = strings.TrimSpace(unescape(p.value))
+		case "N":
+			parts := splitComponents(p.value)
+			c.Last = strings.TrimSpace(parts[0])
+			if len(parts) > 1 {
+				c.First = strings.TrimSpace(parts[1])
+			}
+		case "TEL":
+			if phone == nil || (!phone.preferred() && p.preferred()) {
+				phone = p
+			}
+		case "EMAIL":
+			if email == nil || (!email.preferred() && p.preferred()) {
+				email = p
+			}
+		}
+	}
+
+	if c.First == "" && c.Last == "" && fn != "" {
+		if i := strings.LastIndexByte(fn, ' '); i >= 0 {
+			c.First, c.Last = strings.TrimSpace(fn[:i]), fn[i+1:]
+		} else {
+			c.First = fn
+		}
+	}
+
+	if phone != nil {
+		// vCard 4.0 phone numbers are usually tel: URIs
+		c.Phone = strings.TrimSpace(strings.TrimPrefix(unescape(phone.value), "tel:"))
+	}
+	if email != nil {
+		c.Email = strings.TrimSpace(strings.TrimPrefix(unescape(email.value), "mailto:"))
+	}
+
+	return c
+}
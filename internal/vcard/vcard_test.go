@@ -0,0 +1,247 @@
+package vcard
+
+import (
+	"bytes"
+	"github.com/code-chimp/htmx-go-example/internal/models"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// vcard3 is a vCard 3.0 card as exported by a phone: grouped properties, lower case parameters,
+// TYPE=pref on the second TEL and EMAIL, and properties the app does not store.
+const vcard3 = `BEGIN:VCARD
+VERSION:3.0
+PRODID:-//Apple Inc.//iPhone OS 17.0//EN
+N:O'Brien;Siobhán;;;
+FN:Siobhán O'Brien
+ORG:Example Ltd.;
+item1.EMAIL;type=INTERNET:work@example.com
+item1.X-ABLabel:_$!<Work>!$_
+EMAIL;type=INTERNET;type=HOME;type=pref:home@example.com
+TEL;type=CELL;type=VOICE:+1 555 0100
+TEL;type=HOME;type=VOICE;type=pref:+1 555 0199
+END:VCARD
+`
+
+// vcard4 is a vCard 4.0 card with a UID, tel: and mailto: URIs, PREF=1 on the second TEL and
+// EMAIL, a quoted parameter value and an N folded in the middle of a multibyte rune.
+const vcard4 = "BEGIN:VCARD\n" +
+	"VERSION:4.0\n" +
+	"UID:urn:uuid:4fbe8971-0bc3-424c-9c26-36c3e1eff6b1\n" +
+	"FN:Zoë Ångström\n" +
+	"N:Ångstr\xc3\n" +
+	" \xb6m;Zoë;;;\n" +
+	"TEL;VALUE=uri;TYPE=work:tel:+1-555-0100\n" +
+	"TEL;VALUE=uri;PREF=1;TYPE=\"voice,cell\":tel:+1-555-0199\n" +
+	"EMAIL;TYPE=work:mailto:zoe@example.com\n" +
+	"EMAIL;PREF=1:mailto:zoe.pref@example.com\n" +
+	"NOTE:Met at the conference in Malmö\n" +
+	"END:VCARD\n"
+
+// crlf converts the bare LF line endings of a fixture to CRLF.
+func crlf(s string) string {
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+// parseOne parses input that must hold exactly one vCard.
+func parseOne(t *testing.T, input string) *models.Contact {
+	t.Helper()
+
+	contacts, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contacts) != 1 {
+		t.Fatalf("got %d contacts; want 1", len(contacts))
+	}
+
+	return contacts[0]
+}
+
+func TestParse(t *testing.T) {
+	want3 := &models.Contact{First: "Siobhán", Last: "O'Brien", Phone: "+1 555 0199", Email: "home@example.com"}
+	want4 := &models.Contact{
+		UID:   "urn:uuid:4fbe8971-0bc3-424c-9c26-36c3e1eff6b1",
+		First: "Zoë",
+		Last:  "Ångström",
+		Phone: "+1-555-0199",
+		Email: "zoe.pref@example.com",
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  *models.Contact
+	}{
+		{"3.0 LF", vcard3, want3},
+		{"3.0 CRLF", crlf(vcard3), want3},
+		{"3.0 BOM", "\ufeff" + crlf(vcard3), want3},
+		{"4.0 LF", vcard4, want4},
+		{"4.0 CRLF", crlf(vcard4), want4},
+		{"4.0 BOM", "\ufeff" + vcard4, want4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseOne(t, tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFirstWhenNonePreferred(t *testing.T) {
+	input := "BEGIN:VCARD\nVERSION:4.0\nFN:Ada Lovelace\nTEL:+44 20 0000\nTEL:+44 20 1111\n" +
+		"EMAIL:ada@example.com\nEMAIL:other@example.com\nEND:VCARD\n"
+
+	want := &models.Contact{First: "Ada", Last: "Lovelace", Phone: "+44 20 0000", Email: "ada@example.com"}
+	if got := parseOne(t, input); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestParseEscaping(t *testing.T) {
+	input := "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Doe\\;Smith;John\\, Jr.;;;\r\n" +
+		"TEL:555\\,0100\r\nEMAIL:back\\\\slash@example.com\r\nEND:VCARD\r\n"
+
+	want := &models.Contact{First: "John, Jr.", Last: "Doe;Smith", Phone: "555,0100", Email: `back\slash@example.com`}
+	if got := parseOne(t, input); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"no card", "VERSION:4.0\r\n"},
+		{"missing END", "BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Ada\r\n"},
+		{"missing colon", "BEGIN:VCARD\r\nFN\r\nEND:VCARD\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tt.input)); err == nil {
+				t.Error("got nil error")
+			}
+		})
+	}
+}
+
+func TestWriteVersionEscaping(t *testing.T) {
+	c := &models.Contact{First: "John, Jr.", Last: "Doe;Smith", Phone: "555-0100", Email: `back\slash@example.com`}
+
+	tests := []struct {
+		version string
+		want    []string
+	}{
+		{Version3, []string{`FN:John\, Jr. Doe\;Smith`, `N:Doe\;Smith;John\, Jr.;;;`, "TEL:555-0100", `EMAIL:back\\slash@example.com`}},
+		{Version4, []string{`FN:John\, Jr. Doe\;Smith`, `N:Doe\;Smith;John\, Jr.;;;`, "TEL;VALUE=text:555-0100", `EMAIL:back\\slash@example.com`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteVersion(&buf, c, tt.version); err != nil {
+				t.Fatal(err)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+			want := append([]string{"BEGIN:VCARD", "VERSION:" + tt.version}, tt.want...)
+			want = append(want, "END:VCARD")
+
+			if !reflect.DeepEqual(lines, want) {
+				t.Errorf("got lines\n%q\nwant\n%q", lines, want)
+			}
+		})
+	}
+}
+
+func TestWriteVersionFoldsMultibyteRunes(t *testing.T) {
+	// 2 octets each, so no line can end exactly at the limit without splitting one
+	c := &models.Contact{First: strings.Repeat("ü", 100), Last: "Ω", Email: "u@example.com"}
+
+	var buf bytes.Buffer
+	if err := WriteVersion(&buf, c, Version4); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if !strings.HasSuffix(out, "\r\n") || strings.Contains(strings.ReplaceAll(out, "\r\n", ""), "\n") {
+		t.Fatalf("lines are not all terminated by CRLF: %q", out)
+	}
+
+	folded := 0
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > maxLineOctets {
+			t.Errorf("line of %d octets is longer than %d: %q", len(line), maxLineOctets, line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line splits a UTF-8 sequence: %q", line)
+		}
+		if strings.HasPrefix(line, " ") {
+			folded++
+		}
+	}
+	if folded == 0 {
+		t.Error("no line was folded")
+	}
+
+	if got := parseOne(t, out); got.First != c.First || got.Last != c.Last {
+		t.Errorf("got name %q %q back; want %q %q", got.First, got.Last, c.First, c.Last)
+	}
+}
+
+func TestWriteVersionRejectsUnknownVersion(t *testing.T) {
+	if err := WriteVersion(&bytes.Buffer{}, &models.Contact{}, "2.1"); err == nil {
+		t.Error("got nil error for version 2.1")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	contacts := []*models.Contact{
+		{First: "Ada", Last: "Lovelace", Phone: "+44 20 7946 0000", Email: "ada@example.com"},
+		{UID: "urn:uuid:4fbe8971-0bc3-424c-9c26-36c3e1eff6b1", First: "Zoë", Last: "Ångström", Phone: "+46 8 000 00", Email: "zoe@example.com"},
+		{UID: "a,b;c", First: "John, Jr.", Last: "Doe;Smith", Phone: "555,0100", Email: `back\slash@example.com`},
+		{First: "Multi\nLine", Last: "Name", Phone: "555-0100", Email: "multi@example.com"},
+		{First: strings.Repeat("ü", 40), Last: strings.Repeat("日本", 30), Phone: "555-0101", Email: "long@example.com"},
+		{First: "Mary Ann", Last: "Smith"},
+	}
+
+	for _, version := range []string{Version3, Version4} {
+		for _, c := range contacts {
+			var buf bytes.Buffer
+			if err := WriteVersion(&buf, c, version); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := parseOne(t, buf.String()); !reflect.DeepEqual(got, c) {
+				t.Errorf("%s: got %+v; want %+v", version, got, c)
+			}
+		}
+	}
+}
+
+func TestWriteAll(t *testing.T) {
+	contacts := []*models.Contact{
+		{First: "Ada", Last: "Lovelace", Phone: "555-0100", Email: "ada@example.com"},
+		{First: "Grace", Last: "Hopper", Phone: "555-0101", Email: "grace@example.com"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAll(&buf, contacts); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, contacts) {
+		t.Errorf("got %+v; want %+v", got, contacts)
+	}
+}
@@ -0,0 +1,23 @@
+// Replace htmx's native window.confirm() prompt for hx-confirm with a SweetAlert2 dialog.
+// ref: https://htmx.org/examples/confirm/
+document.addEventListener('htmx:confirm', (evt) => {
+  // only intercept requests that actually asked for confirmation
+  if (!evt.detail.question) {
+    return;
+  }
+
+  evt.preventDefault();
+
+  Swal.fire({
+    title: 'Are you sure?',
+    text: evt.detail.question,
+    icon: 'warning',
+    showCancelButton: true,
+    confirmButtonText: 'Yes, delete',
+    confirmButtonColor: '#b91c1c',
+  }).then((result) => {
+    if (result.isConfirmed) {
+      evt.detail.issueRequest(true);
+    }
+  });
+});